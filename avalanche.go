@@ -0,0 +1,186 @@
+package main
+
+// Tracking of avalanches: cascades of firing spreading through neighbours
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+)
+
+type AvalancheEvent struct {
+	ID           int
+	Start        int // First step
+	Duration     int // Steps
+	Size         int // Firing events
+	Area         int // Distinct cells that fired
+	SeedX, SeedY int // First cell that fired
+}
+
+// Labels clusters of connected firing cells. A cluster continues the
+// avalanches of the previous step that fired in it or next to it, when
+// it touches more than one of them they are merged into the oldest.
+// An avalanche ends at the first step without clusters continuing it
+type AvalancheTracker struct {
+	Done []AvalancheEvent
+
+	label  []int // Avalanche of cells firing at the last step, -1 if not firing
+	active map[int]*AvalancheEvent
+	cells  map[int]map[int]bool // Cells touched by active avalanches
+	merged map[int]int          // Avalanches merged into others
+	next   int
+}
+
+func NewAvalancheTracker() *AvalancheTracker {
+	return &AvalancheTracker{
+		active: make(map[int]*AvalancheEvent),
+		cells:  make(map[int]map[int]bool),
+		merged: make(map[int]int),
+	}
+}
+
+// Avalanche that id was merged into
+func (t *AvalancheTracker) resolve(id int) int {
+	for {
+		m, ok := t.merged[id]
+		if !ok {
+			return id
+		}
+		id = m
+	}
+}
+
+// Adds the firing pattern at given step
+func (t *AvalancheTracker) Observe(step int, g *HexGrid) {
+	n := len(g.Data)
+	if len(t.label) != n {
+		t.Flush()
+		t.label = make([]int, n)
+		for i := range t.label {
+			t.label[i] = -1
+		}
+	}
+	cur := make([]int, n)
+	for i := range cur {
+		cur[i] = -1
+	}
+	continued := make(map[int]bool)
+
+	for start := range g.Data {
+		if !firing(g, start) || cur[start] >= 0 {
+			continue
+		}
+		// Collect the cluster and the avalanches it continues
+		comp := []int{start}
+		cur[start] = 0
+		ids := make(map[int]bool)
+		for k := 0; k < len(comp); k++ {
+			x, y := comp[k]%g.W, comp[k]/g.W
+			if l := t.label[comp[k]]; l >= 0 {
+				ids[t.resolve(l)] = true
+			}
+			for _, nb := range g.nb {
+				nx, ny := g.wrap(x+nb.x_, y+nb.y_)
+				j := ny*g.W + nx
+				if l := t.label[j]; l >= 0 {
+					ids[t.resolve(l)] = true
+				}
+				if firing(g, j) && cur[j] < 0 {
+					cur[j] = 0
+					comp = append(comp, j)
+				}
+			}
+		}
+
+		id := -1
+		for i := range ids {
+			if id < 0 || i < id {
+				id = i
+			}
+		}
+		if id < 0 {
+			id = t.next
+			t.next++
+			t.active[id] = &AvalancheEvent{ID: id, Start: step, SeedX: start % g.W, SeedY: start / g.W}
+			t.cells[id] = make(map[int]bool)
+		}
+		ev := t.active[id]
+		for i := range ids {
+			if i == id {
+				continue
+			}
+			// Merge other avalanches into this
+			o := t.active[i]
+			ev.Size += o.Size
+			if o.Start < ev.Start {
+				ev.Start, ev.SeedX, ev.SeedY = o.Start, o.SeedX, o.SeedY
+			}
+			for c := range t.cells[i] {
+				t.cells[id][c] = true
+			}
+			t.merged[i] = id
+			delete(t.active, i)
+			delete(t.cells, i)
+		}
+		for _, c := range comp {
+			cur[c] = id
+			t.cells[id][c] = true
+		}
+		ev.Size += len(comp)
+		ev.Duration = step - ev.Start + 1
+		continued[id] = true
+	}
+
+	t.end(func(id int) bool { return !continued[id] })
+	// Labels are resolved, forwarding is no longer needed
+	for i, l := range cur {
+		if l >= 0 {
+			cur[i] = t.resolve(l)
+		}
+	}
+	t.merged = make(map[int]int)
+	t.label = cur
+}
+
+// Ends the active avalanches selected, in order of id
+func (t *AvalancheTracker) end(sel func(id int) bool) {
+	var ids []int
+	for id := range t.active {
+		if sel(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		ev := t.active[id]
+		ev.Area = len(t.cells[id])
+		t.Done = append(t.Done, *ev)
+		delete(t.active, id)
+		delete(t.cells, id)
+	}
+}
+
+// Ends the avalanches still going on
+func (t *AvalancheTracker) Flush() {
+	t.end(func(int) bool { return true })
+	for i := range t.label {
+		t.label[i] = -1
+	}
+}
+
+// Writes one row per completed avalanche
+func (t *AvalancheTracker) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "start", "duration", "size", "area", "seed_x", "seed_y"})
+	for _, ev := range t.Done {
+		row := []int{ev.ID, ev.Start, ev.Duration, ev.Size, ev.Area, ev.SeedX, ev.SeedY}
+		rec := make([]string, len(row))
+		for i, v := range row {
+			rec[i] = strconv.Itoa(v)
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
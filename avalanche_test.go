@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAvalancheTracker(t *testing.T) {
+	g := NewGrid(10, 10)
+	tr := NewAvalancheTracker()
+	// Two cascades start apart, grow towards each other and merge,
+	// a third one lasts a single step
+	frames := [][][2]int{
+		{{1, 1}, {6, 1}},
+		{{2, 1}, {5, 1}, {8, 8}},
+		{{3, 1}, {4, 1}},
+		{},
+	}
+	for step, f := range frames {
+		for i := range g.Data {
+			g.Data[i] = 0
+		}
+		for _, c := range f {
+			g.Set(c[0], c[1], 1)
+		}
+		tr.Observe(step, g)
+	}
+	if len(tr.Done) != 2 {
+		t.Fatal("Wrong number of avalanches", tr.Done)
+	}
+	want := AvalancheEvent{ID: 0, Start: 0, Duration: 3, Size: 6, Area: 6, SeedX: 1, SeedY: 1}
+	// Completed avalanches are in order of end
+	if tr.Done[1] != want {
+		t.Error("Wrong merged avalanche", tr.Done[1])
+	}
+	if d := tr.Done[0]; d.Size != 1 || d.Duration != 1 || d.SeedX != 8 {
+		t.Error("Wrong single avalanche", d)
+	}
+
+	var buf bytes.Buffer
+	tr.WriteCSV(&buf)
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 || lines[2] != "0,0,3,6,6,1,1" {
+		t.Error("Wrong CSV", lines)
+	}
+}
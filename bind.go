@@ -0,0 +1,169 @@
+package main
+
+// Bindings of input data to cells. A binding drives a set of cells with a
+// sequence of frames, a frame holding one value for each cell
+
+import "fmt"
+
+// How a binding behaves when the end of data is reached
+type PlayMode int
+
+const (
+	Loop     PlayMode = iota // Start again from the first value
+	Once                     // Release the cell
+	Hold                     // Keep the last value
+	PingPong                 // Go back and forth
+)
+
+var playModes = []string{"loop", "once", "hold", "pingpong"}
+
+func (m PlayMode) String() string {
+	return playModes[m]
+}
+
+func ParsePlayMode(s string) (PlayMode, error) {
+	for i, n := range playModes {
+		if n == s {
+			return PlayMode(i), nil
+		}
+	}
+	return Loop, fmt.Errorf("unknown playback mode %q", s)
+}
+
+// A cell driven by a binding, taking the k-th value of each frame
+type boundCell struct {
+	x, y, k int
+}
+
+type bind struct {
+	x, y  int // Cell where the binding is anchored
+	cells []boundCell
+	size  int       // Number of values in a frame
+	i     int       // Current frame
+	data  []float32 // Frames, one after the other
+	mode  PlayMode
+	every int  // Steps between frames
+	tick  int  // Steps since the current frame was reached
+	dir   int  // Direction of ping-pong playback
+	done  bool // Data is over, release the cells
+}
+
+type BindOption func(*bind)
+
+func Playback(m PlayMode) BindOption {
+	return func(b *bind) {
+		b.mode = m
+	}
+}
+
+// Reads a new value every k steps, instead of every step
+func Every(k int) BindOption {
+	return func(b *bind) {
+		if k > 0 {
+			b.every = k
+		}
+	}
+}
+
+func (b *bind) frames() int {
+	return len(b.data) / b.size
+}
+
+// Returns the current frame and advances, false if the binding is over
+func (b *bind) next() ([]float32, bool) {
+	if b.done {
+		return nil, false
+	}
+	f := b.data[b.i*b.size : (b.i+1)*b.size]
+	if b.tick++; b.tick < b.every {
+		return f, true
+	}
+	b.tick = 0
+	switch b.mode {
+	case Loop:
+		if b.i++; b.i >= b.frames() {
+			b.i = 0
+		}
+	case Once:
+		if b.i++; b.i >= b.frames() {
+			b.i = b.frames() - 1
+			b.done = true
+		}
+	case Hold:
+		if b.i < b.frames()-1 {
+			b.i++
+		}
+	case PingPong:
+		if b.frames() > 1 {
+			if b.i+b.dir < 0 || b.i+b.dir >= b.frames() {
+				b.dir = -b.dir
+			}
+			b.i += b.dir
+		}
+	}
+	return f, true
+}
+
+// This will read the values from vals every time an update
+// is performed, and will automatically set the value of the
+// cell (x,y) to that value after the update
+func (hg *HexGrid) Bind(x, y int, vals []float32, opts ...BindOption) {
+	x, y = hg.wrap(x, y)
+	hg.bindCells(x, y, []boundCell{{x, y, 0}}, 1, vals, opts)
+}
+
+func (hg *HexGrid) bindCells(x, y int, cells []boundCell, size int, data []float32, opts []BindOption) {
+	b := bind{x: x, y: y, cells: cells, size: size, data: data, every: 1, dir: 1}
+	for _, opt := range opts {
+		opt(&b)
+	}
+	found := false
+	for i := range hg.binds {
+		if hg.binds[i].x == x && hg.binds[i].y == y {
+			hg.binds[i] = b // Overwrite existing bindings
+			found = true
+			break
+		}
+	}
+	if !found {
+		hg.binds = append(hg.binds, b)
+	}
+	// Set initial values
+	for _, c := range cells {
+		hg.Set(c.x, c.y, data[c.k])
+	}
+}
+
+// Removes the bindings driving cell (x,y), returns false if it was not bound
+func (hg *HexGrid) Unbind(x, y int) bool {
+	x, y = hg.wrap(x, y)
+	found := false
+	active := hg.binds[:0]
+	for _, b := range hg.binds {
+		drives := false
+		for _, c := range b.cells {
+			drives = drives || (c.x == x && c.y == y)
+		}
+		if drives {
+			found = true
+		} else {
+			active = append(active, b)
+		}
+	}
+	hg.binds = active
+	return found
+}
+
+// Sets bound cells to their next value, releasing the ones which are over
+func (hg *HexGrid) applyBinds() {
+	active := hg.binds[:0]
+	for _, b := range hg.binds {
+		if f, ok := b.next(); ok {
+			for _, c := range b.cells {
+				hg.Set(c.x, c.y, f[c.k])
+			}
+			active = append(active, b)
+		}
+	}
+	hg.binds = active
+}
@@ -0,0 +1,160 @@
+package main
+
+// Experiment files, describing a whole run in JSON
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// An input file bound to a cell
+type BindingConfig struct {
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	File     string `json:"file"`
+	Channel  int    `json:"channel"`  // Index of the channel in the file
+	Column   string `json:"column"`   // Name of the channel, used instead of index if given
+	Playback string `json:"playback"` // Defaults to -playback
+	Every    int    `json:"every"`    // Defaults to -rate_div
+}
+
+type CellConfig struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Besides the keys below, any command line flag can be set in the file
+// using its name as key, e.g. "rows": 30 or "boundary": "clamp"
+type Config struct {
+	Params   Params          `json:"params"`
+	Bindings []BindingConfig `json:"bindings"`
+	Probes   []CellConfig    `json:"probes"`
+	Map      []string        `json:"map"`   // One string per row from the top, # marks walls and i inhibitory cells
+	Links    []Link          `json:"links"` // Between layers, replacing the ones of -feedforward and -feedback
+
+	flags map[string]string
+}
+
+var configKeys = map[string]bool{"params": true, "bindings": true, "probes": true, "map": true, "links": true}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{Params: DefaultParams(), flags: make(map[string]string)}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, jsonError(data, err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, jsonError(data, err)
+	}
+	for k, v := range raw {
+		if configKeys[k] {
+			continue
+		}
+		if flag.Lookup(k) == nil || k == "config" {
+			return nil, fmt.Errorf("unknown key %q", k)
+		}
+		var s string
+		if json.Unmarshal(v, &s) != nil {
+			s = string(v) // Numbers and booleans
+		}
+		c.flags[k] = s
+	}
+	return c, nil
+}
+
+// Adds line numbers to JSON errors
+func jsonError(data []byte, err error) error {
+	var off int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		off = e.Offset
+	case *json.UnmarshalTypeError:
+		off = e.Offset
+	default:
+		return err
+	}
+	line := bytes.Count(data[:off], []byte("\n")) + 1
+	return fmt.Errorf("line %d: %v", line, err)
+}
+
+// Sets flags from file values, except the ones given on the command line
+func (c *Config) SetFlags() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for k, v := range c.flags {
+		if given[k] {
+			continue
+		}
+		if err := flag.Set(k, v); err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// Applies map and bindings to the grid
+func (c *Config) Setup(g *HexGrid, rate float64, mode PlayMode, every int) error {
+	if len(c.Map) > g.H {
+		return fmt.Errorf("map has %d rows, grid %d", len(c.Map), g.H)
+	}
+	for i, row := range c.Map {
+		if len(row) > g.W {
+			return fmt.Errorf("map row %d has %d cells, grid %d", i+1, len(row), g.W)
+		}
+		for x, r := range row {
+			switch r {
+			case '#':
+				g.SetWall(x, g.H-1-i, true)
+			case 'i':
+				g.SetType(x, g.H-1-i, Inhibitory)
+			case '.', ' ':
+			default:
+				return fmt.Errorf("map row %d: unknown cell %q", i+1, r)
+			}
+		}
+	}
+
+	for _, b := range c.Bindings {
+		in, err := LoadInput(b.File, rate)
+		if err != nil {
+			return err
+		}
+		ch := b.Channel
+		if b.Column != "" {
+			ch = -1
+			for i, n := range in.Names {
+				if strings.EqualFold(n, b.Column) {
+					ch = i
+				}
+			}
+			if ch < 0 {
+				return fmt.Errorf("%s: no column %q", b.File, b.Column)
+			}
+		}
+		if ch < 0 || ch >= len(in.Channels) {
+			return fmt.Errorf("%s: no channel %d", b.File, b.Channel)
+		}
+		m := mode
+		if b.Playback != "" {
+			if m, err = ParsePlayMode(b.Playback); err != nil {
+				return err
+			}
+		}
+		e := every
+		if b.Every > 0 {
+			e = b.Every
+		}
+		g.Bind(b.X, b.Y, in.Channels[ch], Playback(m), Every(e))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+// Controls how the simulation advances over time
+
+import (
+	"log"
+	"time"
+)
+
+type RunMode int
+
+const (
+	Running  RunMode = iota // Step every Interval
+	Paused                  // Step only on request
+	MaxSpeed                // Step as fast as possible, render every RenderEvery steps
+)
+
+func (m RunMode) String() string {
+	switch m {
+	case Running:
+		return "running"
+	case Paused:
+		return "paused"
+	case MaxSpeed:
+		return "max speed"
+	}
+	return "unknown"
+}
+
+const (
+	minInterval = 10 * time.Millisecond
+	maxInterval = time.Minute
+)
+
+type Controller struct {
+	Mode        RunMode
+	Interval    time.Duration // Time between steps when running
+	RenderEvery int           // Steps between frames at max speed
+	pending     int           // Steps requested while paused
+	last        time.Time     // Time of the last step
+}
+
+func NewController(interval time.Duration, renderEvery int) *Controller {
+	return &Controller{Running, interval, renderEvery, 0, time.Now()}
+}
+
+// Returns the number of steps to perform before drawing the next frame
+func (c *Controller) Steps(now time.Time) int {
+	n := 0
+	switch c.Mode {
+	case Running:
+		if now.Sub(c.last) > c.Interval {
+			n = 1
+		}
+	case MaxSpeed:
+		n = c.RenderEvery
+	}
+	// Requested steps are performed at max speed
+	if c.pending > 0 {
+		p := c.pending
+		if p > c.RenderEvery {
+			p = c.RenderEvery
+		}
+		c.pending -= p
+		n += p
+	}
+	if n > 0 {
+		c.last = now
+	}
+	return n
+}
+
+func (c *Controller) TogglePause() {
+	if c.Mode == Paused {
+		c.Mode = Running
+	} else {
+		c.Mode = Paused
+		c.pending = 0
+	}
+	log.Println("Simulation", c.Mode)
+}
+
+func (c *Controller) ToggleMaxSpeed() {
+	if c.Mode == MaxSpeed {
+		c.Mode = Running
+	} else {
+		c.Mode = MaxSpeed
+	}
+	log.Println("Simulation", c.Mode)
+}
+
+// Pauses the simulation and performs n more steps
+func (c *Controller) Run(n int) {
+	c.Mode = Paused
+	c.pending += n
+}
+
+func (c *Controller) Faster() {
+	if c.Interval /= 2; c.Interval < minInterval {
+		c.Interval = minInterval
+	}
+	log.Println("Update interval changed to", c.Interval)
+}
+
+func (c *Controller) Slower() {
+	if c.Interval *= 2; c.Interval > maxInterval {
+		c.Interval = maxInterval
+	}
+	log.Println("Update interval changed to", c.Interval)
+}
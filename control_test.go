@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestController(t *testing.T) {
+	now := time.Now()
+	c := NewController(time.Second, 10)
+	if n := c.Steps(now); n != 0 {
+		t.Error("Should wait for the interval, got", n)
+	}
+	if n := c.Steps(now.Add(2 * time.Second)); n != 1 {
+		t.Error("Should step after the interval, got", n)
+	}
+
+	// Requested steps are split in frames of RenderEvery steps
+	c.Run(25)
+	if c.Mode != Paused {
+		t.Error("Running steps should pause the simulation")
+	}
+	total := 0
+	for _, e := range []int{10, 10, 5, 0} {
+		n := c.Steps(now)
+		if n != e {
+			t.Error("Wrong steps in frame", n, e)
+		}
+		total += n
+	}
+	if total != 25 {
+		t.Error("Wrong number of steps", total)
+	}
+
+	c.ToggleMaxSpeed()
+	if n := c.Steps(now); n != 10 {
+		t.Error("Max speed should step RenderEvery times, got", n)
+	}
+
+	// Pausing drops requested steps
+	c.Run(5)
+	c.TogglePause()
+	c.TogglePause()
+	if c.Mode != Paused || c.pending != 0 {
+		t.Error("Pausing should drop pending steps", c.Mode, c.pending)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+// Detection of fixed points and periodic orbits from hashes of states
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+)
+
+// Remembers hashes of the states of the last Window steps, a state seen
+// again means the evolution entered a cycle (period 1 is a fixed point).
+// Equal hashes are assumed to be equal states
+type CycleDetector struct {
+	Window int
+	Period int // 0 when not in a cycle
+	Start  int // Step when the current cycle was entered
+
+	seen   map[uint64]int // Last step each hash was seen
+	recent []uint64       // Hashes of the last Window steps
+}
+
+func NewCycleDetector(window int) *CycleDetector {
+	return &CycleDetector{Window: window, seen: make(map[uint64]int)}
+}
+
+func (c *CycleDetector) Reset() {
+	*c = *NewCycleDetector(c.Window)
+}
+
+// Adds the hash of the state at given step, true when a cycle is entered
+func (c *CycleDetector) Observe(step int, sum uint64) bool {
+	s, ok := c.seen[sum]
+	entered := false
+	if !ok {
+		// Inputs or edits may move the grid out of the cycle
+		c.Period = 0
+	} else if p := step - s; p != c.Period {
+		c.Period, c.Start = p, s
+		entered = true
+	}
+
+	c.seen[sum] = step
+	c.recent = append(c.recent, sum)
+	if len(c.recent) > c.Window {
+		old := c.recent[0]
+		c.recent = c.recent[1:]
+		if c.seen[old] <= step-c.Window {
+			delete(c.seen, old)
+		}
+	}
+	return entered
+}
+
+// Hash of the exact values
+func hashData(data []float32) uint64 {
+	h := fnv.New64a()
+	var b [4]byte
+	for _, v := range data {
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+		h.Write(b[:])
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,127 @@
+package main
+
+// Tools to edit the grid by hand
+
+import (
+	"log"
+	"math/rand"
+)
+
+type Tool int
+
+const (
+	ToolValue     Tool = iota // Paint values
+	ToolThreshold             // Set thresholds
+	ToolWeight                // Set the weight of an edge
+	ToolClear                 // Clear values in a region
+	ToolRandomize             // Randomize a region
+	ToolType                  // Make cells inhibitory
+	numTools
+)
+
+func (t Tool) String() string {
+	switch t {
+	case ToolValue:
+		return "value"
+	case ToolThreshold:
+		return "threshold"
+	case ToolWeight:
+		return "weight"
+	case ToolClear:
+		return "clear"
+	case ToolRandomize:
+		return "randomize"
+	case ToolType:
+		return "inhibitory"
+	}
+	return "unknown"
+}
+
+const maxBrush = 10
+
+type Editor struct {
+	Tool      Tool
+	Radius    int     // Brush radius, 0 is a single cell
+	Value     float32 // Value painted
+	Threshold float32 // Threshold set
+	Weight    float32 // Weight set
+}
+
+func NewEditor() *Editor {
+	return &Editor{ToolValue, 0, 1.0, 0.5, 1.0}
+}
+
+func (ed *Editor) NextTool() {
+	ed.Tool = (ed.Tool + 1) % numTools
+	log.Println("Editing", ed.Tool)
+}
+
+// Changes the brush radius by d
+func (ed *Editor) Grow(d int) {
+	ed.Radius += d
+	if ed.Radius < 0 {
+		ed.Radius = 0
+	} else if ed.Radius > maxBrush {
+		ed.Radius = maxBrush
+	}
+	log.Println("Brush radius", ed.Radius)
+}
+
+// Applies the current tool on the brush centered in (x,y). When erasing,
+// values, thresholds and weights are set to zero instead, and cells are
+// made excitatory
+func (ed *Editor) Apply(g *HexGrid, x, y int, erase bool) {
+	for dy := -ed.Radius; dy <= ed.Radius; dy++ {
+		for dx := -ed.Radius; dx <= ed.Radius; dx++ {
+			if hexDistance(dx, dy) <= ed.Radius {
+				ed.applyCell(g, x+dx, y+dy, erase)
+			}
+		}
+	}
+}
+
+func (ed *Editor) applyCell(g *HexGrid, x, y int, erase bool) {
+	switch ed.Tool {
+	case ToolValue:
+		if erase {
+			g.Set(x, y, 0)
+		} else {
+			g.Set(x, y, ed.Value)
+		}
+	case ToolThreshold:
+		if erase {
+			g.SetT(x, y, 0)
+		} else {
+			g.SetT(x, y, ed.Threshold)
+		}
+	case ToolWeight:
+		// Brush sets all the edges of the cell
+		for k := range g.nb {
+			ed.ApplyEdge(g, x, y, k, erase)
+		}
+	case ToolClear:
+		g.Set(x, y, 0)
+	case ToolRandomize:
+		// Same distributions used in NewGrid
+		g.Set(x, y, rand.Float32()*0.5)
+		g.SetT(x, y, rand.Float32())
+		for k := 0; k < g.stride(); k++ {
+			g.SetW(x, y, k, rand.Float32())
+		}
+	case ToolType:
+		if erase {
+			g.SetType(x, y, Excitatory)
+		} else {
+			g.SetType(x, y, Inhibitory)
+		}
+	}
+}
+
+// Sets the weight between (x,y) and its k-th neighbour
+func (ed *Editor) ApplyEdge(g *HexGrid, x, y, k int, erase bool) {
+	if erase {
+		g.SetEdge(x, y, k, 0)
+	} else {
+		g.SetEdge(x, y, k, ed.Weight)
+	}
+}
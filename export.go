@@ -0,0 +1,135 @@
+package main
+
+// Export of grid histories as NumPy arrays, to be loaded with numpy.load
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Collects the states of a grid. Arrays exported are:
+//
+//	steps       (steps,)            int64
+//	data        (steps, H, W)       float32
+//	weights     (steps, H, W, K)    float32, K weights per cell
+//	thresholds  (steps, H, W)       float32
+//
+// Weights and thresholds are left out if not available
+type Exporter struct {
+	W, H, K            int
+	steps              []int64
+	data, wdata, thres []float32
+	noWeights, noThres bool
+}
+
+func NewExporter(w, h, wlen int) *Exporter {
+	return &Exporter{W: w, H: h, K: wlen / (w * h)}
+}
+
+// Adds a frame, nil slices mark data that was not recorded
+func (ex *Exporter) Add(step int, data, wdata, thres []float32) {
+	ex.steps = append(ex.steps, int64(step))
+	ex.data = append(ex.data, data...)
+	if wdata == nil {
+		ex.noWeights = true
+	}
+	if thres == nil {
+		ex.noThres = true
+	}
+	ex.wdata = append(ex.wdata, wdata...)
+	ex.thres = append(ex.thres, thres...)
+}
+
+// Writes the arrays. If path ends in .npz they are stored in a single
+// archive, otherwise path is a directory where a .npy per array is created
+func (ex *Exporter) Write(path string) error {
+	if strings.ToLower(filepath.Ext(path)) == ".npz" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		z := zip.NewWriter(f)
+		if err := ex.each(func(name string) (io.Writer, error) {
+			return z.Create(name + ".npy")
+		}); err != nil {
+			return err
+		}
+		return z.Close()
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	var last *os.File
+	err := ex.each(func(name string) (io.Writer, error) {
+		if last != nil {
+			last.Close()
+		}
+		var err error
+		last, err = os.Create(filepath.Join(path, name+".npy"))
+		return last, err
+	})
+	if last != nil {
+		last.Close()
+	}
+	return err
+}
+
+// Writes every array on the writer returned by open
+func (ex *Exporter) each(open func(name string) (io.Writer, error)) error {
+	n := len(ex.steps)
+	arrays := []struct {
+		name  string
+		shape []int
+		data  interface{}
+		skip  bool
+	}{
+		{"steps", []int{n}, ex.steps, false},
+		{"data", []int{n, ex.H, ex.W}, ex.data, false},
+		{"weights", []int{n, ex.H, ex.W, ex.K}, ex.wdata, ex.noWeights},
+		{"thresholds", []int{n, ex.H, ex.W}, ex.thres, ex.noThres},
+	}
+	for _, a := range arrays {
+		if a.skip {
+			continue
+		}
+		w, err := open(a.name)
+		if err != nil {
+			return err
+		}
+		if err := WriteNpy(w, a.shape, a.data); err != nil {
+			return fmt.Errorf("%s: %v", a.name, err)
+		}
+	}
+	return nil
+}
+
+// Converts a trace file to NumPy arrays
+func ExportTrace(tracePath, outPath string) error {
+	f, err := os.Open(tracePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr, err := NewTraceReader(f)
+	if err != nil {
+		return err
+	}
+	h := tr.Header
+	ex := NewExporter(h.W, h.H, h.WLen)
+	for {
+		fr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		ex.Add(fr.Step, fr.Data, fr.WData, fr.Thres)
+	}
+	return ex.Write(outPath)
+}
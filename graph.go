@@ -0,0 +1,136 @@
+package main
+
+// Export of weights as a graph, to be analysed in network tools
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An edge between cells, identified by y*W+x
+type graphEdge struct {
+	src, dst int
+	w        float32
+}
+
+// Edges owned by each cell, self loops are left out. In directed mode
+// every cell owns its outgoing edges
+func graphEdges(g *HexGrid) []graphEdge {
+	var es []graphEdge
+	for y := 0; y < g.H; y++ {
+		for x := 0; x < g.W; x++ {
+			for s := 0; s < g.stride(); s++ {
+				n := g.nb[g.slotNbor(s)]
+				nx, ny := g.wrap(x+n.x_, y+n.y_)
+				if nx == x && ny == y {
+					continue
+				}
+				es = append(es, graphEdge{y*g.W + x, ny*g.W + nx, g.GetW(x, y, s)})
+			}
+		}
+	}
+	return es
+}
+
+// Writes the graph in the format given by the extension of path:
+// .graphml, .gexf or .csv (edge list)
+func ExportGraph(path string, g *HexGrid) error {
+	write := map[string]func(io.Writer, *HexGrid) error{
+		".graphml": WriteGraphML,
+		".gexf":    WriteGEXF,
+		".csv":     WriteEdgeCSV,
+	}[strings.ToLower(filepath.Ext(path))]
+	if write == nil {
+		return fmt.Errorf("unknown graph format %q, use .graphml, .gexf or .csv", filepath.Ext(path))
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, g); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func edgeKind(g *HexGrid) string {
+	if g.Directed {
+		return "directed"
+	}
+	return "undirected"
+}
+
+func WriteGraphML(w io.Writer, g *HexGrid) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(bw, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(bw, `  <key id="x" for="node" attr.name="x" attr.type="int"/>`)
+	fmt.Fprintln(bw, `  <key id="y" for="node" attr.name="y" attr.type="int"/>`)
+	fmt.Fprintln(bw, `  <key id="value" for="node" attr.name="value" attr.type="float"/>`)
+	fmt.Fprintln(bw, `  <key id="threshold" for="node" attr.name="threshold" attr.type="float"/>`)
+	fmt.Fprintln(bw, `  <key id="inhibitory" for="node" attr.name="inhibitory" attr.type="boolean"/>`)
+	fmt.Fprintln(bw, `  <key id="weight" for="edge" attr.name="weight" attr.type="float"/>`)
+	fmt.Fprintf(bw, `  <graph id="G" edgedefault="%s">`+"\n", edgeKind(g))
+	for y := 0; y < g.H; y++ {
+		for x := 0; x < g.W; x++ {
+			fmt.Fprintf(bw, `    <node id="n%d"><data key="x">%d</data><data key="y">%d</data>`+
+				`<data key="value">%g</data><data key="threshold">%g</data>`+
+				`<data key="inhibitory">%t</data></node>`+"\n",
+				y*g.W+x, x, y, g.Get(x, y), g.GetT(x, y), g.GetType(x, y) == Inhibitory)
+		}
+	}
+	for _, e := range graphEdges(g) {
+		fmt.Fprintf(bw, `    <edge source="n%d" target="n%d"><data key="weight">%g</data></edge>`+"\n", e.src, e.dst, e.w)
+	}
+	fmt.Fprintln(bw, `  </graph>`)
+	fmt.Fprintln(bw, `</graphml>`)
+	return bw.Flush()
+}
+
+func WriteGEXF(w io.Writer, g *HexGrid) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(bw, `<gexf xmlns="http://gexf.net/1.2" version="1.2">`)
+	fmt.Fprintf(bw, `  <graph defaultedgetype="%s">`+"\n", edgeKind(g))
+	fmt.Fprintln(bw, `    <attributes class="node">`)
+	fmt.Fprintln(bw, `      <attribute id="0" title="x" type="integer"/>`)
+	fmt.Fprintln(bw, `      <attribute id="1" title="y" type="integer"/>`)
+	fmt.Fprintln(bw, `      <attribute id="2" title="value" type="float"/>`)
+	fmt.Fprintln(bw, `      <attribute id="3" title="threshold" type="float"/>`)
+	fmt.Fprintln(bw, `      <attribute id="4" title="inhibitory" type="boolean"/>`)
+	fmt.Fprintln(bw, `    </attributes>`)
+	fmt.Fprintln(bw, `    <nodes>`)
+	for y := 0; y < g.H; y++ {
+		for x := 0; x < g.W; x++ {
+			fmt.Fprintf(bw, `      <node id="%d" label="(%d,%d)"><attvalues>`+
+				`<attvalue for="0" value="%d"/><attvalue for="1" value="%d"/>`+
+				`<attvalue for="2" value="%g"/><attvalue for="3" value="%g"/>`+
+				`<attvalue for="4" value="%t"/></attvalues></node>`+"\n",
+				y*g.W+x, x, y, x, y, g.Get(x, y), g.GetT(x, y), g.GetType(x, y) == Inhibitory)
+		}
+	}
+	fmt.Fprintln(bw, `    </nodes>`)
+	fmt.Fprintln(bw, `    <edges>`)
+	for i, e := range graphEdges(g) {
+		fmt.Fprintf(bw, `      <edge id="%d" source="%d" target="%d" weight="%g"/>`+"\n", i, e.src, e.dst, e.w)
+	}
+	fmt.Fprintln(bw, `    </edges>`)
+	fmt.Fprintln(bw, `  </graph>`)
+	fmt.Fprintln(bw, `</gexf>`)
+	return bw.Flush()
+}
+
+// One edge per line: ids and coordinates of both cells, then the weight
+func WriteEdgeCSV(w io.Writer, g *HexGrid) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "source,target,source_x,source_y,target_x,target_y,weight")
+	for _, e := range graphEdges(g) {
+		fmt.Fprintf(bw, "%d,%d,%d,%d,%d,%d,%g\n", e.src, e.dst, e.src%g.W, e.src/g.W, e.dst%g.W, e.dst/g.W, e.w)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestGraphExport(t *testing.T) {
+	g := NewGrid(4, 3)
+	g.SetW(1, 1, 2, 0.25)
+	if n := len(graphEdges(g)); n != 4*3*3 {
+		t.Error("Wrong number of edges on a torus", n)
+	}
+
+	var buf bytes.Buffer
+	WriteEdgeCSV(&buf, g)
+	if !strings.Contains(buf.String(), "\n5,6,1,1,2,1,0.25\n") {
+		t.Error("Missing edge (1,1)-(2,1)")
+	}
+
+	// Both XML formats must be well formed
+	for name, write := range map[string]func(*bytes.Buffer){
+		"graphml": func(b *bytes.Buffer) { WriteGraphML(b, g) },
+		"gexf":    func(b *bytes.Buffer) { WriteGEXF(b, g) },
+	} {
+		buf.Reset()
+		write(&buf)
+		d := xml.NewDecoder(&buf)
+		for {
+			_, err := d.Token()
+			if err != nil {
+				if err != io.EOF {
+					t.Error(name, err)
+				}
+				break
+			}
+		}
+	}
+
+	// Clamped borders have no edges out of the grid
+	b, _ := Boundary("clamp")
+	if n := len(graphEdges(NewGrid(4, 3, b))); n >= 4*3*3 {
+		t.Error("Self loops should be left out", n)
+	}
+}
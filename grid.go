@@ -1,9 +1,19 @@
 package main
 
-import "math/rand"
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
 
 // The weight from (x,y) to (x+x_, y+y_) is in position (x+wx_ y+wy_, ww_)
-var nbors = [6]struct{ x_, y_, wx_, wy_, ww_ int }{
+type nbor struct{ x_, y_, wx_, wy_, ww_ int }
+
+// The six nearest neighbours, the opposite of the k-th is the 5-k-th
+var nbors = [6]nbor{
 	{-1, 1, 0, 0, 0},
 	{0, 1, 0, 0, 1},
 	{1, 0, 0, 0, 2},
@@ -12,18 +22,73 @@ var nbors = [6]struct{ x_, y_, wx_, wy_, ww_ int }{
 	{1, -1, 1, -1, 0},
 }
 
-type bind struct {
-	x, y, i int
-	data    []float32
+// Parameters of the update rule
+type Params struct {
+	ActivationThreshold     float32 `json:"activation_threshold"` // Cells above this are firing
+	DecayFactor             float32 `json:"decay_factor"`
+	WeightIncreaseFactor    float32 `json:"weight_increase_factor"`
+	WeightDecreaseFactor    float32 `json:"weight_decrease_factor"`
+	ThresholdIncreaseFactor float32 `json:"threshold_increase_factor"`
+	ThresholdDecreaseFactor float32 `json:"threshold_decrease_factor"`
+
+	Rule             string  `json:"rule"`              // Learning rule for weights, hebbian or stdp
+	TraceDecay       float32 `json:"trace_decay"`       // Spike traces are multiplied by this every step
+	STDPPotentiation float32 `json:"stdp_potentiation"` // Weight gained when a spike follows the input
+	STDPDepression   float32 `json:"stdp_depression"`   // Weight lost when a spike precedes the input
+
+	// Homeostasis, disabled by zero values
+	WeightSum           float32 `json:"weight_sum"`           // Sum of the input weights of each cell
+	TargetRate          float32 `json:"target_rate"`          // Firing rate thresholds are regulated towards
+	RateDecay           float32 `json:"rate_decay"`           // Memory of the running average of firing rates
+	ThresholdAdaptation float32 `json:"threshold_adaptation"` // Threshold change per unit of rate error
+	MinWeight           float32 `json:"min_weight"`
+	MinThreshold        float32 `json:"min_threshold"`
+}
+
+// Learning rules
+const (
+	RuleHebbian = "hebbian" // Edges between cells firing together are strengthened
+	RuleSTDP    = "stdp"    // Spike-timing-dependent plasticity
+)
+
+func DefaultParams() Params {
+	return Params{
+		ActivationThreshold:     0.20,
+		DecayFactor:             0.75,
+		WeightIncreaseFactor:    1.02,
+		WeightDecreaseFactor:    0.99,
+		ThresholdIncreaseFactor: 1.01,
+		ThresholdDecreaseFactor: 0.99,
+		Rule:                    RuleHebbian,
+		TraceDecay:              0.8,
+		STDPPotentiation:        0.05,
+		STDPDepression:          0.055,
+		RateDecay:               0.99,
+		ThresholdAdaptation:     0.01,
+	}
+}
+
+func (p Params) Validate() error {
+	switch p.Rule {
+	case "", RuleHebbian, RuleSTDP:
+		return nil
+	}
+	return fmt.Errorf("unknown learning rule %q", p.Rule)
 }
 
-func (b *bind) next() float32 {
-	v := b.data[b.i]
-	b.i++
-	if b.i >= len(b.data) {
-		b.i = 0
+// Inhibitory cells lower the activation of their neighbours
+type CellType uint8
+
+const (
+	Excitatory CellType = iota
+	Inhibitory
+)
+
+func (t CellType) String() string {
+	if t == Inhibitory {
+		return "inhibitory"
 	}
-	return v
+	return "excitatory"
 }
 
 type HexGrid struct {
@@ -31,39 +96,227 @@ type HexGrid struct {
 	Data  []float32 // Value
 	WData []float32 // Weights
 	Thres []float32 // Threshold
+	Types []CellType
+	Input []float32 // External input added to activations, nil for none
+	Trace []float32 // Spike trace of each cell, used by STDP
+	Rate  []float32 // Running average of firing, used by homeostasis
 	xWrap func(int, int) int
 	yWrap func(int, int) int
 
+	P        Params
+	Seed     int64 // Seed used for the initial state
+	Directed bool  // Outgoing weights for each neighbour instead of shared ones
+	Radius   int   // Distance of the farthest neighbours
+
+	nb    []nbor // Neighbours within Radius, see neighbourhood
+	opp   []int  // Opposite of each neighbour
+	owned []int  // Neighbours whose edges are stored in the cell, when undirected
+
+	inhibitory float64 // Fraction of inhibitory cells in the initial state
+
 	binds []bind
+	walls []bool // Cells that never fire
+}
+
+type GridOption func(*HexGrid)
+
+// Initializes the grid with a given seed, making runs reproducible
+func Seed(s int64) GridOption {
+	return func(hg *HexGrid) {
+		hg.Seed = s
+	}
+}
+
+func WithParams(p Params) GridOption {
+	return func(hg *HexGrid) {
+		hg.P = p
+	}
+}
+
+// Stores a weight for each direction of an edge, so that influence
+// between neighbours can be asymmetric
+func Directed() GridOption {
+	return func(hg *HexGrid) {
+		hg.Directed = true
+	}
+}
+
+// Makes inhibitory a random fraction f of cells
+func InhibitoryFraction(f float64) GridOption {
+	return func(hg *HexGrid) {
+		hg.inhibitory = f
+	}
+}
+
+// Connects cells to all the ones within distance r
+func Radius(r int) GridOption {
+	return func(hg *HexGrid) {
+		if r > 0 {
+			hg.Radius = r
+		}
+	}
+}
+
+// Neighbours within distance r, in blocks of rings of increasing distance.
+// Every ring starts with the half of cells having dy>0, or dy=0 and dx>0,
+// by decreasing angle, followed by their opposites in reverse order: ring 1
+// is nbors and the opposite of the i-th cell of a ring of n is the n-1-i-th.
+// In undirected mode cells store the edges of the first halves, in order
+func neighbourhood(r int) (nb []nbor, opp, owned []int) {
+	for d := 1; d <= r; d++ {
+		var half []nbor
+		for dy := 0; dy <= d; dy++ {
+			for dx := -d; dx <= d; dx++ {
+				if hexDistance(dx, dy) == d && (dy > 0 || dx > 0) {
+					half = append(half, nbor{x_: dx, y_: dy})
+				}
+			}
+		}
+		angle := func(n nbor) float64 {
+			return math.Atan2(float64(n.y_)*pho, float64(n.x_)+0.5*float64(n.y_))
+		}
+		sort.Slice(half, func(i, j int) bool { return angle(half[i]) > angle(half[j]) })
+
+		start, hl := len(nb), len(half)
+		for i, n := range half {
+			n.ww_ = len(owned)
+			owned = append(owned, start+i)
+			nb = append(nb, n)
+		}
+		for i := hl - 1; i >= 0; i-- {
+			n := half[i]
+			nb = append(nb, nbor{-n.x_, -n.y_, -n.x_, -n.y_, nb[start+i].ww_})
+		}
+		for i := 0; i < 2*hl; i++ {
+			opp = append(opp, start+2*hl-1-i)
+		}
+	}
+	return
 }
 
 func torus(x, xn int) int {
 	return (x%xn + xn) % xn
 }
 
+// Cells out of the grid are the ones on the border
+func clamp(x, xn int) int {
+	if x < 0 {
+		return 0
+	}
+	if x >= xn {
+		return xn - 1
+	}
+	return x
+}
+
+// Cells out of the grid are mirrored back, without repeating the border
+func mirror(x, xn int) int {
+	if xn == 1 {
+		return 0
+	}
+	x = torus(x, 2*(xn-1))
+	if x >= xn {
+		return 2*(xn-1) - x
+	}
+	return x
+}
+
+var boundaries = map[string]func(int, int) int{
+	"torus":   torus,
+	"clamp":   clamp,
+	"reflect": mirror,
+}
+
+// Parses boundary modes: torus, clamp or reflect, or two of them
+// separated by a comma to use different modes for x and y
+func Boundary(spec string) (GridOption, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid boundary %q", spec)
+	}
+	var fs [2]func(int, int) int
+	for i, p := range parts {
+		var ok bool
+		if fs[i], ok = boundaries[strings.TrimSpace(p)]; !ok {
+			return nil, fmt.Errorf("unknown boundary mode %q", p)
+		}
+	}
+	return func(hg *HexGrid) {
+		hg.xWrap, hg.yWrap = fs[0], fs[1]
+	}, nil
+}
+
 // Every cell has 3 edges: West, North, East (the other 3 are owned by lower cells)
-func NewGrid(w, h int) *HexGrid {
+// In directed mode every cell has 6 edges, the outgoing ones in nbors order.
+// With larger radius, edges are the ones of all the neighbours in hg.nb
+func NewGrid(w, h int, opts ...GridOption) *HexGrid {
+	hg := &HexGrid{
+		W:     w,
+		H:     h,
+		xWrap: torus,
+		yWrap: torus,
+		P:     DefaultParams(),
+		Seed:  time.Now().UnixNano(),
+		binds: make([]bind, 0),
+
+		Radius: 1,
+	}
+	for _, opt := range opts {
+		opt(hg)
+	}
+	hg.nb, hg.opp, hg.owned = neighbourhood(hg.Radius)
+
+	rng := rand.New(rand.NewSource(hg.Seed))
 	data := make([]float32, w*h)
-	wdata := make([]float32, w*h*3)
+	wdata := make([]float32, w*h*hg.stride())
 	tdata := make([]float32, w*h)
 	// Weights are initialized to 1
 	for i := range data {
-		data[i] = rand.Float32() * 0.5
-		tdata[i] = rand.Float32()
+		data[i] = rng.Float32() * 0.5
+		tdata[i] = rng.Float32()
 	}
 	for i := range wdata {
-		wdata[i] = rand.Float32()
+		wdata[i] = rng.Float32()
+	}
+	hg.Data, hg.WData, hg.Thres = data, wdata, tdata
+	hg.Types = make([]CellType, w*h)
+	if hg.inhibitory > 0 {
+		for i := range hg.Types {
+			if rng.Float64() < hg.inhibitory {
+				hg.Types[i] = Inhibitory
+			}
+		}
+	}
+	return hg
+}
+
+// Returns a deep copy of the grid, bindings included
+func (hg *HexGrid) Clone() *HexGrid {
+	c := *hg
+	c.Data = append([]float32(nil), hg.Data...)
+	c.WData = append([]float32(nil), hg.WData...)
+	c.Thres = append([]float32(nil), hg.Thres...)
+	c.Types = append([]CellType(nil), hg.Types...)
+	if hg.Input != nil {
+		c.Input = append([]float32(nil), hg.Input...)
+	}
+	if hg.Trace != nil {
+		c.Trace = append([]float32(nil), hg.Trace...)
 	}
-	return &HexGrid{
-		w,
-		h,
-		data,
-		wdata,
-		tdata,
-		torus,
-		torus,
-		make([]bind, 0),
+	if hg.Rate != nil {
+		c.Rate = append([]float32(nil), hg.Rate...)
 	}
+	c.binds = append([]bind(nil), hg.binds...)
+	c.walls = append([]bool(nil), hg.walls...)
+	return &c
+}
+
+// Brings the grid back to the state of a clone
+func (hg *HexGrid) Restore(c *HexGrid) {
+	*hg = *c.Clone()
 }
 
 func (hg *HexGrid) wrap(x, y int) (x_, y_ int) {
@@ -82,14 +335,50 @@ func (hg *HexGrid) Set(x, y int, v float32) {
 	hg.Data[y*hg.W+x] = v
 }
 
+// Number of weights stored for each cell
+func (hg *HexGrid) stride() int {
+	if hg.Directed {
+		return len(hg.nb)
+	}
+	return len(hg.owned)
+}
+
+// Neighbour whose edge is stored in slot s of a cell
+func (hg *HexGrid) slotNbor(s int) int {
+	if hg.Directed {
+		return s
+	}
+	return hg.owned[s]
+}
+
+// Weights of the edges with the six nearest neighbours, as drawn by the
+// viewer: three per cell, or six in directed mode. wdata is laid out as WData
+func (hg *HexGrid) RingWeights(wdata []float32) []float32 {
+	s, n := hg.stride(), 3
+	if hg.Directed {
+		n = 6
+	}
+	if s == n {
+		return wdata
+	}
+	// Ring 1 comes first in both layouts
+	rw := make([]float32, hg.W*hg.H*n)
+	for i := 0; i < hg.W*hg.H; i++ {
+		copy(rw[i*n:(i+1)*n], wdata[i*s:])
+	}
+	return rw
+}
+
 func (hg *HexGrid) GetW(x, y, w int) float32 {
 	x, y = hg.wrap(x, y)
-	return hg.WData[y*hg.W*3+x*3+w]
+	s := hg.stride()
+	return hg.WData[y*hg.W*s+x*s+w]
 }
 
 func (hg *HexGrid) SetW(x, y, w int, v float32) {
 	x, y = hg.wrap(x, y)
-	hg.WData[y*hg.W*3+x*3+w] = v
+	s := hg.stride()
+	hg.WData[y*hg.W*s+x*s+w] = v
 }
 
 func (hg *HexGrid) GetT(x, y int) float32 {
@@ -102,42 +391,104 @@ func (hg *HexGrid) SetT(x, y int, v float32) {
 	hg.Thres[y*hg.W+x] = v
 }
 
-// This will read the values from vals every time an update
-// is performed, and will automatically set the value of the
-// cell (x,y) to that value after the update
-func (hg *HexGrid) Bind(x, y int, vals []float32) {
-	b := bind{x, y, 0, vals}
-	found := false
-	for i := range hg.binds {
-		if hg.binds[i].x == x && hg.binds[i].y == y {
-			hg.binds[i] = b // Overwrite existing bindings
-			found = true
-			break
+func (hg *HexGrid) GetType(x, y int) CellType {
+	x, y = hg.wrap(x, y)
+	return hg.Types[y*hg.W+x]
+}
+
+func (hg *HexGrid) SetType(x, y int, t CellType) {
+	x, y = hg.wrap(x, y)
+	hg.Types[y*hg.W+x] = t
+}
+
+// Sign of the contribution of (x,y) to the activation of its neighbours.
+// Weights are magnitudes, so all the edges of a cell have the same sign
+// (Dale's principle) and learning never changes it
+func (hg *HexGrid) sign(x, y int) float32 {
+	if hg.GetType(x, y) == Inhibitory {
+		return -1
+	}
+	return 1
+}
+
+// Types as values for the viewer: 1 for inhibitory cells, 0 otherwise
+func (hg *HexGrid) TypeValues() []float32 {
+	tv := make([]float32, len(hg.Types))
+	for i, t := range hg.Types {
+		if t == Inhibitory {
+			tv[i] = 1
 		}
 	}
-	if !found {
-		hg.binds = append(hg.binds, b)
+	return tv
+}
+
+// Weight of the edge between (x,y) and its k-th neighbour in hg.nb,
+// in directed mode the one going from (x,y) to the neighbour
+func (hg *HexGrid) GetEdge(x, y, k int) float32 {
+	if hg.Directed {
+		return hg.GetW(x, y, k)
+	}
+	n := hg.nb[k]
+	return hg.GetW(x+n.wx_, y+n.wy_, n.ww_)
+}
+
+func (hg *HexGrid) SetEdge(x, y, k int, v float32) {
+	if hg.Directed {
+		hg.SetW(x, y, k, v)
+		return
+	}
+	n := hg.nb[k]
+	hg.SetW(x+n.wx_, y+n.wy_, n.ww_, v)
+}
+
+// Weight of the input (x,y) receives from its k-th neighbour. The
+// opposite neighbour of the neighbour is (x,y) itself
+func (hg *HexGrid) inWeight(x, y, k int) float32 {
+	if hg.Directed {
+		n := hg.nb[k]
+		return hg.GetW(x+n.x_, y+n.y_, hg.opp[k])
+	}
+	return hg.GetEdge(x, y, k)
+}
+
+// Distance in cells between two cells whose offset is (dx,dy)
+func hexDistance(dx, dy int) int {
+	d := abs(dx)
+	if a := abs(dy); a > d {
+		d = a
+	}
+	if a := abs(dx + dy); a > d {
+		d = a
+	}
+	return d
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
 	}
-	hg.Set(x, y, vals[0]) // Set initial value
+	return x
 }
 
-// Returns the weights for this edge
-func (hg *HexGrid) ContactWeights(x, y int) [6]float32 {
-	return [6]float32{
-		hg.GetW(x, y, 0),
-		hg.GetW(x, y, 1),
-		hg.GetW(x, y, 2),
-		hg.GetW(x-1, y, 2),
-		hg.GetW(x, y-1, 1),
-		hg.GetW(x+1, y-1, 0),
+// Returns the weights of the inputs of this cell, in hg.nb order,
+// negative for inputs from inhibitory cells
+func (hg *HexGrid) ContactWeights(x, y int) []float32 {
+	cw := make([]float32, len(hg.nb))
+	for k, n := range hg.nb {
+		cw[k] = hg.sign(x+n.x_, y+n.y_) * hg.inWeight(x, y, k)
 	}
+	return cw
 }
 
 // Computes the output value of a cell, summing its weighted inputs
 func (hg *HexGrid) Activation(x, y int) float32 {
 	var act float32
-	for _, n := range nbors {
-		act += hg.Get(x+n.x_, y+n.y_) * hg.GetW(x+n.wx_, y+n.wy_, n.ww_)
+	for k, n := range hg.nb {
+		act += hg.sign(x+n.x_, y+n.y_) * hg.Get(x+n.x_, y+n.y_) * hg.inWeight(x, y, k)
+	}
+	if hg.Input != nil {
+		x, y = hg.wrap(x, y)
+		act += hg.Input[y*hg.W+x]
 	}
 	// Binary activation
 	if act < hg.GetT(x, y) {
@@ -148,6 +499,34 @@ func (hg *HexGrid) Activation(x, y int) float32 {
 	//return act
 }
 
+// Summary statistics of the grid state
+type Stats struct {
+	MeanValue     float32 // Mean activation of cells
+	Firing        float32 // Fraction of cells above the activation threshold
+	MeanThreshold float32
+	MeanWeight    float32
+}
+
+func (hg *HexGrid) Stats() Stats {
+	var st Stats
+	for i, v := range hg.Data {
+		st.MeanValue += v
+		st.MeanThreshold += hg.Thres[i]
+		if v > hg.P.ActivationThreshold {
+			st.Firing++
+		}
+	}
+	n := float32(len(hg.Data))
+	st.MeanValue /= n
+	st.Firing /= n
+	st.MeanThreshold /= n
+	for _, w := range hg.WData {
+		st.MeanWeight += w
+	}
+	st.MeanWeight /= float32(len(hg.WData))
+	return st
+}
+
 /*
 	C'é una soglia di attivazione
 	Se il neurone ha un valore sopra quella soglia, in update decade di un certo fattore
@@ -159,71 +538,249 @@ func (hg *HexGrid) Activation(x, y int) float32 {
 func (hg *HexGrid) Update() {
 	// This can be kept to avoid reallocating memory all the times
 	val := make([]float32, hg.W*hg.H)
-	wei := make([]float32, hg.W*hg.H*3)
+	wei := make([]float32, len(hg.WData))
 	thr := make([]float32, hg.W*hg.H)
 
-	const (
-		ActivationThreshold     = 0.20
-		DecayFactor             = 0.75
-		WeightIncreaseFactor    = 1.02
-		WeightDecreaseFactor    = 0.99
-		ThresholdIncreaseFactor = 1.01
-		ThresholdDecreaseFactor = 0.99
-	)
+	p := &hg.P
 
 	// For every position
 	for i := 0; i < hg.H; i++ {
 		for j := 0; j < hg.W; j++ {
 			a := hg.Activation(j, i)
 			t := hg.GetT(j, i)
-			if hg.Get(j, i) > ActivationThreshold {
+			if hg.Get(j, i) > p.ActivationThreshold {
 				// If greater than the threshold, decay
-				val[i*hg.W+j] = hg.Get(j, i) * DecayFactor
+				val[i*hg.W+j] = hg.Get(j, i) * p.DecayFactor
 				if a == 1 {
 					// If activated, we might be too sensible to this stimuli
 					// As it seems to trigger me too often, increase threshold
-					t *= ThresholdIncreaseFactor
+					t *= p.ThresholdIncreaseFactor
 				} else {
 					// Decrease threshold
-					t *= ThresholdDecreaseFactor
+					t *= p.ThresholdDecreaseFactor
 				}
 			} else {
 				// If less than threshold, compute activation
 				val[i*hg.W+j] = a
 				// Decrease threshold
-				t *= ThresholdDecreaseFactor
+				t *= p.ThresholdDecreaseFactor
+			}
+			if p.TargetRate > 0 {
+				// Regulated by homeostasis instead
+				t = hg.GetT(j, i)
 			}
 			thr[i*hg.W+j] = t
 		}
 	}
 	// Save updated values so we can use Get methods
+	old := hg.Data
 	hg.Data = val
 	// Also save thresholds even if we don't need them
 	hg.Thres = thr
 
 	// Apply bound values
-	for i := range hg.binds {
-		bx, by := hg.binds[i].x, hg.binds[i].y
-		nv := hg.binds[i].next()
-		hg.Set(bx, by, nv)
+	hg.applyBinds()
+	hg.applyWalls()
+
+	if p.Rule == RuleSTDP {
+		hg.stdp(old, wei)
+	} else {
+		hg.hebbian(old, wei)
 	}
+	// Save weight data as well
+	hg.WData = wei
+	hg.homeostasis()
+}
 
-	// Weight depends on the newly computed value
+// Keeps activity from dying out or saturating in long runs
+func (hg *HexGrid) homeostasis() {
+	p := &hg.P
+	if p.WeightSum > 0 {
+		hg.normalize(p.WeightSum)
+	}
+	if p.TargetRate > 0 {
+		if hg.Rate == nil {
+			hg.Rate = make([]float32, hg.W*hg.H)
+			for i := range hg.Rate {
+				hg.Rate[i] = p.TargetRate
+			}
+		}
+		for i, v := range hg.Data {
+			var f float32
+			if v > p.ActivationThreshold {
+				f = 1
+			}
+			hg.Rate[i] = hg.Rate[i]*p.RateDecay + f*(1-p.RateDecay)
+			// Cells firing too often become harder to activate
+			hg.Thres[i] += p.ThresholdAdaptation * (hg.Rate[i] - p.TargetRate)
+		}
+	}
+	for i, t := range hg.Thres {
+		if t < p.MinThreshold {
+			hg.Thres[i] = p.MinThreshold
+		}
+	}
+	for i, w := range hg.WData {
+		if w < p.MinWeight {
+			hg.WData[i] = p.MinWeight
+		}
+	}
+}
+
+// Scales weights so that the input weights of every cell sum to sum.
+// Undirected edges are shared by two cells and are scaled by the geometric
+// mean of their factors, so sums approach the target over a few steps.
+// Weights are kept below 1
+func (hg *HexGrid) normalize(sum float32) {
+	f := make([]float32, hg.W*hg.H)
 	for i := 0; i < hg.H; i++ {
 		for j := 0; j < hg.W; j++ {
-			for k := 0; k < 3; k++ {
-				if hg.Get(j, i) > ActivationThreshold && hg.Get(j+nbors[k].x_, i+nbors[k].y_) > ActivationThreshold {
-					nw := hg.GetW(j, i, k) * WeightIncreaseFactor
+			var in float32
+			for k := range hg.nb {
+				in += hg.inWeight(j, i, k)
+			}
+			f[i*hg.W+j] = 1
+			if in > 0 {
+				f[i*hg.W+j] = sum / in
+			}
+		}
+	}
+	s := hg.stride()
+	for i := 0; i < hg.H; i++ {
+		for j := 0; j < hg.W; j++ {
+			c := i*hg.W + j
+			for k := 0; k < s; k++ {
+				n := hg.nb[hg.slotNbor(k)]
+				x, y := hg.wrap(j+n.x_, i+n.y_)
+				g := f[y*hg.W+x]
+				if !hg.Directed {
+					g = float32(math.Sqrt(float64(g * f[c])))
+				}
+				w := hg.WData[c*s+k] * g
+				if w > 1 {
+					w = 1
+				}
+				hg.WData[c*s+k] = w
+			}
+		}
+	}
+}
+
+// Weight depends on the newly computed value
+// In directed mode, the edge from a cell is strengthened when the cell
+// was firing before the update and the neighbour fires after it
+func (hg *HexGrid) hebbian(old, wei []float32) {
+	p := &hg.P
+	s := hg.stride()
+	for i := 0; i < hg.H; i++ {
+		for j := 0; j < hg.W; j++ {
+			pre := hg.Get(j, i)
+			if hg.Directed {
+				pre = old[i*hg.W+j]
+			}
+			for k := 0; k < s; k++ {
+				n := hg.nb[hg.slotNbor(k)]
+				if pre > p.ActivationThreshold && hg.Get(j+n.x_, i+n.y_) > p.ActivationThreshold {
+					nw := hg.GetW(j, i, k) * p.WeightIncreaseFactor
 					if nw > 1.0 {
 						nw = 1.0
 					}
-					wei[i*hg.W*3+j*3+k] = nw
+					wei[i*hg.W*s+j*s+k] = nw
 				} else {
-					wei[i*hg.W*3+j*3+k] = hg.GetW(j, i, k) * WeightDecreaseFactor
+					wei[i*hg.W*s+j*s+k] = hg.GetW(j, i, k) * p.WeightDecreaseFactor
 				}
 			}
 		}
 	}
-	// Save weight data as well
-	hg.WData = wei
+}
+
+// A cell spikes when it crosses the threshold. Traces of spikes decay over
+// time: the edge from a cell to a neighbour is strengthened when the
+// neighbour spikes after the cell, by an amount proportional to the trace
+// of the cell, and weakened when the cell spikes after the neighbour.
+// Undirected edges have no order: a spike following one of the other cell
+// strengthens them either way
+func (hg *HexGrid) stdp(old, wei []float32) {
+	p := &hg.P
+	if hg.Trace == nil {
+		hg.Trace = make([]float32, hg.W*hg.H)
+	}
+	spike := make([]bool, hg.W*hg.H)
+	for i := range hg.Trace {
+		spike[i] = old[i] <= p.ActivationThreshold && hg.Data[i] > p.ActivationThreshold
+		hg.Trace[i] *= p.TraceDecay
+	}
+	// Change of the edge from a to b
+	change := func(a, b int) (d float32) {
+		if spike[b] {
+			d += p.STDPPotentiation * hg.Trace[a]
+		}
+		if spike[a] {
+			d -= p.STDPDepression * hg.Trace[b]
+		}
+		return
+	}
+	shared := func(a, b int) (d float32) {
+		if spike[b] {
+			d += p.STDPPotentiation * hg.Trace[a]
+		}
+		if spike[a] {
+			d += p.STDPPotentiation * hg.Trace[b]
+		}
+		return
+	}
+	s := hg.stride()
+	for i := 0; i < hg.H; i++ {
+		for j := 0; j < hg.W; j++ {
+			c := i*hg.W + j
+			for k := 0; k < s; k++ {
+				n := hg.nb[hg.slotNbor(k)]
+				x, y := hg.wrap(j+n.x_, i+n.y_)
+				var d float32
+				if hg.Directed {
+					d = change(c, y*hg.W+x)
+				} else {
+					d = shared(c, y*hg.W+x)
+				}
+				nw := hg.GetW(j, i, k) + d
+				if nw > 1.0 {
+					nw = 1.0
+				} else if nw < 0 {
+					nw = 0
+				}
+				wei[c*s+k] = nw
+			}
+		}
+	}
+	// Spikes of this step only affect the following ones
+	for i, sp := range spike {
+		if sp {
+			hg.Trace[i]++
+		}
+	}
+}
+
+// Walls are kept at zero, so they never excite their neighbours
+func (hg *HexGrid) SetWall(x, y int, wall bool) {
+	if hg.walls == nil {
+		hg.walls = make([]bool, hg.W*hg.H)
+	}
+	x, y = hg.wrap(x, y)
+	hg.walls[y*hg.W+x] = wall
+	if wall {
+		hg.Data[y*hg.W+x] = 0
+	}
+}
+
+func (hg *HexGrid) IsWall(x, y int) bool {
+	x, y = hg.wrap(x, y)
+	return hg.walls != nil && hg.walls[y*hg.W+x]
+}
+
+func (hg *HexGrid) applyWalls() {
+	for i, w := range hg.walls {
+		if w {
+			hg.Data[i] = 0
+		}
+	}
 }
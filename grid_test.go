@@ -1,6 +1,20 @@
 package main
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
+
+// Sets all values to zero, and thresholds and weights to the given ones
+func quietGrid(g *HexGrid, thres, weight float32) {
+	for i := range g.Data {
+		g.Data[i] = 0
+		g.Thres[i] = thres
+	}
+	for i := range g.WData {
+		g.WData[i] = weight
+	}
+}
 
 func TestGettersSetters(t *testing.T) {
 	g := NewGrid(3, 4)
@@ -26,3 +40,308 @@ func TestGettersSetters(t *testing.T) {
 	}
 	// TODO fare lo stesso con i pesi
 }
+
+func TestEdges(t *testing.T) {
+	g := NewGrid(4, 5)
+
+	// Every edge is seen from both cells, with opposite directions
+	for k, n := range nbors {
+		g.SetEdge(1, 1, k, float32(k+1))
+		if w := g.GetEdge(1+n.x_, 1+n.y_, len(nbors)-1-k); w != float32(k+1) {
+			t.Error("Wrong weight from neighbour", k, w)
+		}
+		if w := g.ContactWeights(1, 1)[k]; w != float32(k+1) {
+			t.Error("Wrong contact weight", k, w)
+		}
+		if d := hexDistance(n.x_, n.y_); d != 1 {
+			t.Error("Neighbour at wrong distance", k, d)
+		}
+	}
+}
+
+func TestNeighbourhood(t *testing.T) {
+	nb, opp, owned := neighbourhood(1)
+	for k, n := range nbors {
+		if nb[k] != n || opp[k] != 5-k {
+			t.Error("Ring 1 should be nbors", k, nb[k], opp[k])
+		}
+	}
+	if len(owned) != 3 {
+		t.Error("Wrong number of owned edges", owned)
+	}
+
+	nb, opp, owned = neighbourhood(2)
+	if len(nb) != 18 || len(owned) != 9 {
+		t.Fatal("Wrong size of radius 2", len(nb), len(owned))
+	}
+	for k, n := range nb {
+		if d := hexDistance(n.x_, n.y_); (k < 6 && d != 1) || (k >= 6 && d != 2) {
+			t.Error("Neighbour in the wrong ring", k, n)
+		}
+		if o := nb[opp[k]]; o.x_ != -n.x_ || o.y_ != -n.y_ {
+			t.Error("Wrong opposite", k, n, o)
+		}
+	}
+
+	// Edges with distant cells are shared too
+	g := NewGrid(7, 7, Radius(2))
+	if len(g.WData) != 7*7*9 {
+		t.Fatal("Wrong number of weights", len(g.WData))
+	}
+	for k, n := range g.nb {
+		g.SetEdge(3, 3, k, float32(k+1))
+		if w := g.GetEdge(3+n.x_, 3+n.y_, g.opp[k]); w != float32(k+1) {
+			t.Error("Wrong weight from neighbour", k, w)
+		}
+	}
+	if rw := g.RingWeights(g.WData); len(rw) != 7*7*3 || rw[3] != g.WData[9] {
+		t.Error("Wrong ring weights")
+	}
+}
+
+func TestDirected(t *testing.T) {
+	g := NewGrid(4, 5, Directed())
+	if len(g.WData) != 4*5*6 {
+		t.Fatal("Wrong number of weights", len(g.WData))
+	}
+
+	// Directions of an edge are independent, inputs come from neighbours
+	for k, n := range nbors {
+		g.SetEdge(1, 1, k, float32(k+1))
+		g.SetEdge(1+n.x_, 1+n.y_, len(nbors)-1-k, float32(-k-1))
+		if w := g.GetEdge(1, 1, k); w != float32(k+1) {
+			t.Error("Wrong outgoing weight", k, w)
+		}
+		if w := g.ContactWeights(1, 1)[k]; w != float32(-k-1) {
+			t.Error("Wrong contact weight", k, w)
+		}
+	}
+
+	// Only the edge from the cell firing first is strengthened
+	quietGrid(g, 10, 0.5)
+	g.Set(1, 1, 1)
+	g.SetT(2, 1, 0.1) // East neighbour is activated by the update
+	g.Update()
+	if g.Get(2, 1) != 1 {
+		t.Fatal("East neighbour should fire")
+	}
+	if w := g.GetEdge(1, 1, 2); w <= 0.5 {
+		t.Error("Edge to the later cell should grow", w)
+	}
+	if w := g.GetEdge(2, 1, 3); w >= 0.5 {
+		t.Error("Edge to the earlier cell should decay", w)
+	}
+}
+
+func TestSTDP(t *testing.T) {
+	p := DefaultParams()
+	p.Rule = RuleSTDP
+	// West cell spikes first, then its east neighbour
+	spikes := func(opts ...GridOption) *HexGrid {
+		g := NewGrid(4, 5, append(opts, WithParams(p))...)
+		quietGrid(g, 10, 0.5)
+		g.Input = make([]float32, len(g.Data))
+		g.SetT(1, 1, 0.1)
+		g.SetT(2, 1, 0.1)
+		g.Input[1*4+1] = 1
+		g.Update()
+		g.Input[1*4+1] = 0
+		g.Input[1*4+2] = 1
+		g.Update()
+		if g.Get(2, 1) != 1 {
+			t.Fatal("East neighbour should fire")
+		}
+		return g
+	}
+	grow := 0.5 + p.STDPPotentiation*p.TraceDecay
+
+	g := spikes(Directed())
+	if w := g.GetEdge(1, 1, 2); math.Abs(float64(w-grow)) > 1e-6 {
+		t.Error("Edge to the later cell should grow", w, grow)
+	}
+	if w, e := g.GetEdge(2, 1, 3), 0.5-p.STDPDepression*p.TraceDecay; math.Abs(float64(w-e)) > 1e-6 {
+		t.Error("Edge to the earlier cell should decay", w, e)
+	}
+	if w := g.GetEdge(1, 1, 0); w != 0.5 {
+		t.Error("Edges to silent cells should not change", w)
+	}
+
+	// Shared edges grow with the causal term only
+	g = spikes()
+	if w := g.GetEdge(1, 1, 2); math.Abs(float64(w-grow)) > 1e-6 {
+		t.Error("Shared edge should grow", w, grow)
+	}
+	if w := g.GetEdge(1, 1, 0); w != 0.5 {
+		t.Error("Edges to silent cells should not change", w)
+	}
+}
+
+func TestHomeostasis(t *testing.T) {
+	// Input weights of every cell sum to the target
+	p := DefaultParams()
+	p.WeightSum = 1.5
+	g := NewGrid(4, 5, Directed(), WithParams(p))
+	g.Update()
+	for i := 0; i < g.H; i++ {
+		for j := 0; j < g.W; j++ {
+			var sum float32
+			for k := range g.nb {
+				sum += g.inWeight(j, i, k)
+			}
+			if math.Abs(float64(sum-p.WeightSum)) > 1e-4 {
+				t.Error("Wrong sum of input weights", j, i, sum)
+			}
+		}
+	}
+
+	// Silent cells lower their thresholds, down to the bound, and weights
+	// do not decay below theirs
+	p = DefaultParams()
+	p.TargetRate = 0.1
+	p.ThresholdAdaptation = 1
+	p.MinThreshold = 9.5
+	p.WeightDecreaseFactor = 0.5
+	p.MinWeight = 0.2
+	g = NewGrid(4, 5, WithParams(p))
+	quietGrid(g, 10, 0.5)
+	g.Update()
+	if tr := g.GetT(1, 1); tr >= 10 || tr <= 9.5 {
+		t.Error("Threshold should decrease towards the target rate", tr)
+	}
+	for n := 0; n < 100; n++ {
+		g.Update()
+	}
+	for i := range g.Data {
+		if g.Thres[i] != 9.5 {
+			t.Error("Threshold should stop at the bound", g.Thres[i])
+		}
+	}
+	for _, w := range g.WData {
+		if w != 0.2 {
+			t.Error("Weight should stop at the bound", w)
+		}
+	}
+}
+
+func TestInhibitory(t *testing.T) {
+	g := NewGrid(20, 20, InhibitoryFraction(0.25), Seed(1))
+	n := 0
+	for _, ty := range g.Types {
+		if ty == Inhibitory {
+			n++
+		}
+	}
+	if n < 50 || n > 150 {
+		t.Error("Wrong number of inhibitory cells", n)
+	}
+	if g.Clone().Types[0] != g.Types[0] {
+		t.Error("Types should be cloned")
+	}
+
+	// Two active neighbours, one of them inhibitory, cancel out
+	g = NewGrid(5, 5)
+	quietGrid(g, 0.1, 0.5)
+	g.Set(1, 2, 1)
+	g.Set(3, 2, 1)
+	if g.Activation(2, 2) != 1 {
+		t.Error("Excitatory neighbours should activate the cell")
+	}
+	g.SetType(3, 2, Inhibitory)
+	if g.Activation(2, 2) != 0 {
+		t.Error("Inhibitory neighbour should prevent activation")
+	}
+	if cw := g.ContactWeights(2, 2); cw[2] != -0.5 || cw[3] != 0.5 {
+		t.Error("Wrong signed weights", cw)
+	}
+	// Learning changes magnitudes only: the edge from the inhibitory cell
+	// to a cell firing with it grows, and its input stays negative
+	g.Set(2, 2, 1)
+	g.Update()
+	if w := g.GetEdge(3, 2, 3); w <= 0.5 {
+		t.Error("Edge between cells firing together should grow", w)
+	}
+	if cw := g.ContactWeights(2, 2); cw[2] != -g.GetEdge(3, 2, 3) {
+		t.Error("Input from the inhibitory cell should stay negative", cw)
+	}
+}
+
+func TestBindPlayback(t *testing.T) {
+	cases := []struct {
+		mode  PlayMode
+		every int
+		exp   []float32 // Values after each update, -1 when released
+	}{
+		{Loop, 1, []float32{1, 2, 3, 1, 2, 3, 1}},
+		{Once, 1, []float32{1, 2, 3, -1, -1}},
+		{Hold, 1, []float32{1, 2, 3, 3, 3}},
+		{PingPong, 1, []float32{1, 2, 3, 2, 1, 2, 3}},
+		{Loop, 2, []float32{1, 1, 2, 2, 3, 3, 1}},
+	}
+
+	for _, c := range cases {
+		b := bind{size: 1, data: []float32{1, 2, 3}, mode: c.mode, every: c.every, dir: 1}
+		for i, e := range c.exp {
+			f, ok := b.next()
+			if (e < 0 && ok) || (e >= 0 && (!ok || f[0] != e)) {
+				t.Error("Wrong value for", c.mode, c.every, "at", i, f, ok)
+			}
+		}
+	}
+
+	g := NewGrid(3, 3)
+	g.Bind(1, 1, []float32{1}, Playback(Once))
+	if g.Unbind(0, 0) || !g.Unbind(1, 1) || len(g.binds) != 0 {
+		t.Error("Wrong unbinding")
+	}
+}
+
+func TestBindRegion(t *testing.T) {
+	g := NewGrid(6, 6)
+	p := &Pattern{W: 2, H: 2, Frames: [][]float32{{1, 2, 3, 4}, {5, 6, 7, 8}}}
+	g.BindRegion(2, 2, p, RectShape)
+
+	// Top row of the pattern is the upper row of cells
+	if g.Get(2, 2) != 3 || g.Get(3, 2) != 4 || g.Get(2, 3) != 1 || g.Get(3, 3) != 2 {
+		t.Error("Wrong initial frame", g.Get(2, 2), g.Get(3, 2), g.Get(2, 3), g.Get(3, 3))
+	}
+	g.applyBinds()
+	g.applyBinds()
+	if g.Get(2, 2) != 7 || g.Get(3, 3) != 6 {
+		t.Error("Wrong second frame", g.Get(2, 2), g.Get(3, 3))
+	}
+	if !g.Unbind(3, 3) || len(g.binds) != 0 {
+		t.Error("Region should be unbound from any of its cells")
+	}
+
+	g.BindRegion(2, 2, MovingBar(5, 5, 1, false), HexShape)
+	if n := len(g.binds[0].cells); n != 19 {
+		t.Error("Hexagon of radius 2 should have 19 cells, got", n)
+	}
+}
+
+func TestBoundary(t *testing.T) {
+	if clamp(-2, 5) != 0 || clamp(7, 5) != 4 || clamp(3, 5) != 3 {
+		t.Error("Wrong clamp")
+	}
+	if mirror(-1, 5) != 1 || mirror(5, 5) != 3 || mirror(9, 5) != 1 || mirror(4, 5) != 4 {
+		t.Error("Wrong mirror", mirror(-1, 5), mirror(5, 5), mirror(9, 5))
+	}
+	b, err := Boundary("torus,clamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGrid(4, 4, b)
+	g.Set(0, 3, 1)
+	if g.Get(4, 3) != 1 || g.Get(0, 5) != 1 {
+		t.Error("Wrong mixed boundary")
+	}
+	if _, err := Boundary("torus,wall"); err == nil {
+		t.Error("Unknown mode should fail")
+	}
+
+	g.SetWall(1, 1, true)
+	g.Update()
+	if !g.IsWall(1, 1) || g.Get(1, 1) != 0 {
+		t.Error("Walls should stay at zero")
+	}
+}
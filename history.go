@@ -0,0 +1,142 @@
+package main
+
+// Ring buffer keeping the last states of a grid, to look back at them.
+// Values and thresholds are stored as they are, while weights are stored
+// in full only every few frames (keyframes): other frames keep the XOR of
+// the weight bits with the previous frame, which is mostly made of zeros in
+// the sign and exponent bytes and is compressed with flate
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+type frame struct {
+	step    int
+	data    []float32
+	thres   []float32
+	weights []float32 // Set only in keyframes
+	delta   []byte    // Compressed XOR with previous weights otherwise
+}
+
+type History struct {
+	frames   []frame
+	head, n  int
+	keyEvery int       // Distance between keyframes
+	sinceKey int       // Frames pushed after the last keyframe
+	last     []float32 // Weights of the most recent frame
+}
+
+func NewHistory(capacity, keyEvery int) *History {
+	return &History{frames: make([]frame, capacity), keyEvery: keyEvery}
+}
+
+func (h *History) Len() int {
+	return h.n
+}
+
+// Removes all the frames
+func (h *History) Clear() {
+	h.head, h.n, h.sinceKey, h.last = 0, 0, 0, nil
+}
+
+// Position in the ring of the i-th frame, from the oldest one
+func (h *History) pos(i int) int {
+	return (h.head - h.n + i + 2*len(h.frames)) % len(h.frames)
+}
+
+// Saves the current state of the grid
+func (h *History) Push(step int, g *HexGrid) {
+	if h.n == len(h.frames) {
+		// The oldest frame is going to be overwritten: if the next one
+		// depends on it, turn it into a keyframe
+		if h.n > 1 && h.frames[h.pos(1)].weights == nil {
+			next := &h.frames[h.pos(1)]
+			next.weights = applyDelta(h.frames[h.pos(0)].weights, next.delta)
+			next.delta = nil
+		}
+		h.n--
+	}
+
+	f := frame{
+		step:  step,
+		data:  append([]float32(nil), g.Data...),
+		thres: append([]float32(nil), g.Thres...),
+	}
+	if h.n == 0 || h.sinceKey+1 >= h.keyEvery || len(h.last) != len(g.WData) {
+		f.weights = append([]float32(nil), g.WData...)
+		h.sinceKey = 0
+	} else {
+		f.delta = makeDelta(h.last, g.WData)
+		h.sinceKey++
+	}
+	h.last = append(h.last[:0], g.WData...)
+
+	h.frames[h.head] = f
+	h.head = (h.head + 1) % len(h.frames)
+	h.n++
+}
+
+// Returns the i-th frame, from the oldest one
+func (h *History) Frame(i int) (step int, data, weights, thres []float32) {
+	// Go back to the last keyframe, then apply the deltas
+	k := i
+	for h.frames[h.pos(k)].weights == nil {
+		k--
+	}
+	weights = h.frames[h.pos(k)].weights
+	for k < i {
+		k++
+		weights = applyDelta(weights, h.frames[h.pos(k)].delta)
+	}
+	f := h.frames[h.pos(i)]
+	return f.step, f.data, weights, f.thres
+}
+
+// Returns the index of the frame at given step, or -1 if not stored
+func (h *History) Find(step int) int {
+	if h.n == 0 {
+		return -1
+	}
+	i := step - h.frames[h.pos(0)].step
+	if i < 0 || i >= h.n {
+		return -1
+	}
+	return i
+}
+
+// Compresses the XOR of the bits of cur and prev. Bytes are grouped by
+// significance, so that the (mostly zero) high bytes are contiguous
+func makeDelta(prev, cur []float32) []byte {
+	planes := make([]byte, 4*len(cur))
+	for i := range cur {
+		x := math.Float32bits(prev[i]) ^ math.Float32bits(cur[i])
+		for b := 0; b < 4; b++ {
+			planes[b*len(cur)+i] = byte(x >> (8 * uint(3-b)))
+		}
+	}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write(planes)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Reconstructs the weights from the previous ones and the delta
+func applyDelta(prev []float32, delta []byte) []float32 {
+	planes := make([]byte, 4*len(prev))
+	if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(delta)), planes); err != nil {
+		panic(err)
+	}
+	cur := make([]float32, len(prev))
+	for i := range cur {
+		x := binary.BigEndian.Uint32([]byte{
+			planes[i], planes[len(prev)+i], planes[2*len(prev)+i], planes[3*len(prev)+i],
+		})
+		cur[i] = math.Float32frombits(math.Float32bits(prev[i]) ^ x)
+	}
+	return cur
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestHistory(t *testing.T) {
+	g := NewGrid(6, 5)
+	h := NewHistory(7, 3)
+
+	// Keep a copy of every state to compare with
+	var states []*HexGrid
+	for s := 0; s < 20; s++ {
+		h.Push(s, g)
+		states = append(states, g.Clone())
+		g.Update()
+	}
+
+	if h.Len() != 7 {
+		t.Fatal("Wrong length", h.Len())
+	}
+	for i := 0; i < h.Len(); i++ {
+		step, data, weights, thres := h.Frame(i)
+		if step != 13+i {
+			t.Error("Wrong step", i, step)
+		}
+		if h.Find(step) != i {
+			t.Error("Wrong index for step", step, h.Find(step))
+		}
+		exp := states[step]
+		for k := range exp.WData {
+			if weights[k] != exp.WData[k] {
+				t.Fatal("Wrong weight", step, k, weights[k], exp.WData[k])
+			}
+		}
+		for k := range exp.Data {
+			if data[k] != exp.Data[k] || thres[k] != exp.Thres[k] {
+				t.Fatal("Wrong value or threshold", step, k)
+			}
+		}
+	}
+	if h.Find(12) != -1 || h.Find(20) != -1 {
+		t.Error("Steps out of history should not be found")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+// Heads-up display showing the state of the simulation over the grid
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	hudWidth  = 260
+	hudMargin = 8
+)
+
+type HUD struct {
+	*Overlay
+	Visible bool
+}
+
+// Creates a HUD able to show up to lines rows of text
+func NewHUD(lines int) *HUD {
+	face := basicfont.Face7x13
+	h := lines*face.Height + 2*hudMargin
+	return &HUD{NewOverlay(hudWidth, h), true}
+}
+
+// Renders the lines of text, replacing the previous content
+func (hud *HUD) SetText(lines []string) {
+	hud.Clear(color.RGBA{0, 0, 0, 160})
+	face := basicfont.Face7x13
+	d := font.Drawer{
+		Dst:  hud.Img,
+		Src:  image.NewUniform(color.RGBA{255, 255, 255, 255}),
+		Face: face,
+	}
+	for i, l := range lines {
+		d.Dot = fixed.P(hudMargin, hudMargin+face.Ascent+i*face.Height)
+		d.DrawString(l)
+	}
+	hud.Upload()
+}
+
+func (hud *HUD) Draw() {
+	if hud.Visible {
+		hud.Overlay.Draw()
+	}
+}
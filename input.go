@@ -0,0 +1,255 @@
+package main
+
+// Loading of input data to be bound to cells. Every input is made of
+// one or more channels, each of them can be bound to a different cell
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Input struct {
+	Names    []string    // Name of each channel
+	Channels [][]float32 // Values of each channel, one per step
+}
+
+// Loads input data, the format is chosen by file extension:
+//
+//	.csv  comma separated columns, with optional header naming them
+//	.wav  audio, one channel per audio channel, resampled to rate steps per second
+//	.npy  NumPy array, 1D for one channel or 2D with one column per channel
+//	other whitespace separated floats, in a single channel
+func LoadInput(path string, rate float64) (*Input, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("invalid input rate %v", rate)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var in *Input
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		in, err = readCSV(f)
+	case ".wav":
+		in, err = readWAV(f, rate)
+	case ".npy":
+		in, err = readNpyInput(f)
+	default:
+		in, err = readText(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i, ch := range in.Channels {
+		if len(ch) == 0 {
+			return nil, fmt.Errorf("%s: channel %s is empty", path, in.Names[i])
+		}
+	}
+	return in, nil
+}
+
+// Names channels by their number
+func numberedChannels(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+	}
+	return names
+}
+
+func readText(r io.Reader) (*Input, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var data []float32
+	for i, line := range strings.Split(string(buf), "\n") {
+		for _, s := range strings.Fields(line) {
+			val, err := strconv.ParseFloat(s, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			data = append(data, float32(val))
+		}
+	}
+	return &Input{[]string{"0"}, [][]float32{data}}, nil
+}
+
+func readCSV(r io.Reader) (*Input, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	var in *Input
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if in == nil {
+			in = &Input{Channels: make([][]float32, len(rec))}
+			// A first row which is not numeric is the header
+			if _, err := strconv.ParseFloat(rec[0], 32); err != nil {
+				in.Names = rec
+				continue
+			}
+			in.Names = numberedChannels(len(rec))
+		}
+		for i, s := range rec {
+			val, err := strconv.ParseFloat(s, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d, column %s: %v", line, in.Names[i], err)
+			}
+			in.Channels[i] = append(in.Channels[i], float32(val))
+		}
+	}
+	if in == nil {
+		return nil, fmt.Errorf("no data")
+	}
+	return in, nil
+}
+
+func readNpyInput(r io.Reader) (*Input, error) {
+	shape, data, err := ReadNpy(r)
+	if err != nil {
+		return nil, err
+	}
+	switch len(shape) {
+	case 1:
+		return &Input{[]string{"0"}, [][]float32{data}}, nil
+	case 2:
+		in := &Input{numberedChannels(shape[1]), make([][]float32, shape[1])}
+		for i := 0; i < shape[0]; i++ {
+			for j := 0; j < shape[1]; j++ {
+				in.Channels[j] = append(in.Channels[j], data[i*shape[1]+j])
+			}
+		}
+		return in, nil
+	}
+	return nil, fmt.Errorf("expected 1 or 2 dimensions, found shape %v", shape)
+}
+
+// Reads a RIFF WAVE file with integer or float samples, normalized in [-1,1]
+func readWAV(r io.Reader, rate float64) (*Input, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAVE file")
+	}
+
+	var (
+		format, channels, bits uint16
+		sampleRate             uint32
+		samples                []byte
+	)
+	for samples == nil {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, fmt.Errorf("missing data chunk: %v", err)
+		}
+		size := binary.LittleEndian.Uint32(hdr[4:])
+		// Sizes are not trusted, data grows as it is actually read
+		chunk, err := io.ReadAll(io.LimitReader(r, int64(size)))
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) < int(size) && string(hdr[0:4]) != "data" {
+			return nil, fmt.Errorf("chunk %q truncated", hdr[0:4])
+		}
+		if size%2 == 1 {
+			// Chunks are padded to even size
+			io.CopyN(io.Discard, r, 1)
+		}
+		switch string(hdr[0:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("fmt chunk too short")
+			}
+			format = binary.LittleEndian.Uint16(chunk[0:])
+			channels = binary.LittleEndian.Uint16(chunk[2:])
+			sampleRate = binary.LittleEndian.Uint32(chunk[4:])
+			bits = binary.LittleEndian.Uint16(chunk[14:])
+			if format == 0xFFFE && size >= 26 {
+				// Extensible format, actual format is in the subformat GUID
+				format = binary.LittleEndian.Uint16(chunk[24:])
+			}
+		case "data":
+			// Use what is available if the file was truncated
+			samples = chunk
+		}
+	}
+	if channels == 0 {
+		return nil, fmt.Errorf("missing fmt chunk")
+	}
+	if sampleRate == 0 {
+		return nil, fmt.Errorf("invalid sample rate 0")
+	}
+	if (format != 1 || bits == 0 || bits%8 != 0 || bits > 32) && (format != 3 || bits != 32) {
+		return nil, fmt.Errorf("unsupported sample format %d with %d bits", format, bits)
+	}
+
+	width := int(bits / 8)
+	frames := len(samples) / (width * int(channels))
+	in := &Input{numberedChannels(int(channels)), make([][]float32, channels)}
+	for c := range in.Channels {
+		ch := make([]float32, frames)
+		for i := range ch {
+			b := samples[(i*int(channels)+c)*width:]
+			switch {
+			case format == 3:
+				ch[i] = math.Float32frombits(binary.LittleEndian.Uint32(b))
+			case width == 1:
+				ch[i] = (float32(b[0]) - 128) / 128 // 8 bits samples are unsigned
+			default:
+				// Sign-extend little endian integers of any width
+				var v int32
+				for k := width - 1; k >= 0; k-- {
+					v = v<<8 | int32(b[k])
+				}
+				v <<= uint(32 - bits)
+				ch[i] = float32(v) / float32(math.MaxInt32)
+			}
+		}
+		in.Channels[c] = resample(ch, float64(sampleRate), rate)
+	}
+	return in, nil
+}
+
+// Resamples data from rate from to rate to, averaging the samples that
+// fall in each output sample when reducing the rate
+func resample(data []float32, from, to float64) []float32 {
+	n := int(float64(len(data)) * to / from)
+	out := make([]float32, n)
+	ratio := from / to
+	for i := range out {
+		lo, hi := int(float64(i)*ratio), int(float64(i+1)*ratio)
+		if hi <= lo {
+			// Increasing the rate, take the nearest sample
+			out[i] = data[lo]
+			continue
+		}
+		if hi > len(data) {
+			hi = len(data)
+		}
+		var sum float32
+		for _, v := range data[lo:hi] {
+			sum += v
+		}
+		out[i] = sum / float32(hi-lo)
+	}
+	return out
+}
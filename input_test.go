@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	in, err := readCSV(strings.NewReader("left, right\n1, 2\n# comment\n3, 4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(in.Names) != 2 || in.Names[1] != "right" {
+		t.Error("Wrong names", in.Names)
+	}
+	if len(in.Channels[0]) != 2 || in.Channels[0][1] != 3 || in.Channels[1][1] != 4 {
+		t.Error("Wrong channels", in.Channels)
+	}
+
+	_, err = readCSV(strings.NewReader("1, 2\n3, x\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Error("Error should report the line, got", err)
+	}
+}
+
+func TestReadText(t *testing.T) {
+	// Lines can be of any length
+	in, err := readText(strings.NewReader(strings.Repeat("0.5 ", 20000) + "\n1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(in.Channels[0]) != 20001 || in.Channels[0][20000] != 1 {
+		t.Error("Wrong data", len(in.Channels[0]))
+	}
+
+	_, err = readText(strings.NewReader("1 2\n3 x\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Error("Error should report the line, got", err)
+	}
+}
+
+func TestLoadInputRate(t *testing.T) {
+	if _, err := LoadInput("data.wav", -10); err == nil || !strings.Contains(err.Error(), "rate") {
+		t.Error("Negative rate should be rejected, got", err)
+	}
+}
+
+func TestResample(t *testing.T) {
+	data := []float32{1, 3, 5, 7, 9, 11}
+	if r := resample(data, 6, 3); len(r) != 3 || r[0] != 2 || r[2] != 10 {
+		t.Error("Wrong downsampling", r)
+	}
+	if r := resample(data, 6, 12); len(r) != 12 || r[1] != 1 || r[11] != 11 {
+		t.Error("Wrong upsampling", r)
+	}
+}
+
+// WAVE file with a single channel, whose data chunk claims size bytes
+func wavData(rate uint32, bits uint16, size uint32, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, []uint32{16})
+	binary.Write(&b, binary.LittleEndian, []uint16{1, 1})
+	binary.Write(&b, binary.LittleEndian, []uint32{rate, rate * uint32(bits) / 8})
+	binary.Write(&b, binary.LittleEndian, []uint16{bits / 8, bits})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, []uint32{size})
+	b.Write(data)
+	return b.Bytes()
+}
+
+func TestReadWAV(t *testing.T) {
+	in, err := readWAV(bytes.NewReader(wavData(4, 8, 4, []byte{128, 255, 0, 128})), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch := in.Channels[0]; len(ch) != 4 || ch[0] != 0 || ch[2] != -1 {
+		t.Error("Wrong samples", ch)
+	}
+
+	// Truncated data is used as it is, whatever size is claimed
+	in, err = readWAV(bytes.NewReader(wavData(4, 8, 0xFFFFFFFF, []byte{128, 0})), 4)
+	if err != nil || len(in.Channels[0]) != 2 {
+		t.Error("Truncated data should be read", err)
+	}
+
+	if _, err := readWAV(bytes.NewReader(wavData(4, 0, 2, []byte{0, 0})), 4); err == nil {
+		t.Error("Samples of 0 bits should be rejected")
+	}
+	if _, err := readWAV(bytes.NewReader(wavData(0, 8, 2, []byte{0, 0})), 4); err == nil {
+		t.Error("Sample rate 0 should be rejected")
+	}
+}
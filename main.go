@@ -2,100 +2,665 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	glad "github.com/akiross/go-glad"
 	"github.com/go-gl/glfw/v3.2/glfw"
-	"io/ioutil"
+	"io"
 	"log"
+	"math"
+	"os"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 )
 
 var (
-	rows = flag.Int("rows", 20, "Number of rows in the grid")
-	cols = flag.Int("cols", 20, "NUmber of columns in the grid")
+	configPath = flag.String("config", "", "JSON experiment file, flags given on the command line override its values")
+	headless   = flag.Bool("headless", false, "Run without a window for -steps steps, printing probes to stdout")
+	maxSteps   = flag.Int("steps", 0, "Stop after this number of steps (0 runs forever)")
+	stopCycle  = flag.Bool("stop_on_cycle", false, "Stop headless runs when values enter a fixed point or cycle")
+
+	sweepSeeds = flag.Int("seeds", 1, "Runs with different seeds for each combination in a sweep, starting from -seed")
+	sweepJobs  = flag.Int("jobs", runtime.NumCPU(), "Runs performed at the same time in a sweep")
+	sweepOut   = flag.String("sweep_out", "", "File where the sweep summary is written (default stdout)")
+
+	rows     = flag.Int("rows", 20, "Number of rows in the grid")
+	cols     = flag.Int("cols", 20, "NUmber of columns in the grid")
+	boundary = flag.String("boundary", "torus", "Boundary mode: torus, clamp or reflect, or x,y modes like torus,clamp")
+	directed = flag.Bool("directed", false, "Store a weight for each direction of edges")
+	radius   = flag.Int("radius", 1, "Cells are connected to all the ones within this distance")
+	inhibit  = flag.Float64("inhibitory", 0, "Fraction of inhibitory cells, chosen at random")
+	rule     = flag.String("rule", "", "Learning rule for weights: hebbian or stdp (default from params)")
+
+	layers      = flag.String("layers", "", "Layers stacked over the grid, as comma separated ROWSxCOLS")
+	feedforward = flag.Float64("feedforward", 0.5, "Weight of links from each layer to the next")
+	feedback    = flag.Float64("feedback", 0, "Weight of links from each layer to the previous")
+	field       = flag.Int("field", 1, "Radius of the receptive field of links between layers")
 
 	width  = flag.Int("width", 1000, "Window width")
 	height = flag.Int("height", 600, "Window height")
 
-	inPath = flag.String("input_data", "", "File containing input data (space separated floats)")
+	inPath    = flag.String("input_data", "", "File containing input data (.csv, .wav, .npy or space separated floats)")
+	inputRate = flag.Float64("input_rate", 100, "Steps per second of audio when loading .wav input")
+	playback  = flag.String("playback", "loop", "What bound inputs do at the end of data: loop, once, hold or pingpong")
+	rateDiv   = flag.Int("rate_div", 1, "Steps between input samples")
+
+	patternSpec  = flag.String("pattern", "", "Pattern bound with shift+right click: bar:WxH, hbar:WxH or a glob of images")
+	patternShape = flag.String("pattern_shape", "rect", "Shape of the region covered by the pattern: rect or hex")
+
+	seed          = flag.Int64("seed", 0, "Seed for the initial state (0 picks one from the clock)")
+	recordPath    = flag.String("record", "", "Record the run to this trace file")
+	recordWeights = flag.Bool("record_weights", false, "Include weights in the trace")
+	recordThres   = flag.Bool("record_thres", false, "Include thresholds in the trace")
+	traceChunk    = flag.Int("trace_chunk", 100, "Steps in each compressed chunk of the trace")
+	exportPath    = flag.String("export", "", "On exit, save the run as NumPy arrays to this .npz file or directory")
+	avalanchePath = flag.String("avalanches", "", "Track avalanches and save them on exit to this CSV file")
+	graphPath     = flag.String("graph", "", "Save weights on exit and with W as a graph: .graphml, .gexf or .csv edge list")
+
+	runSteps    = flag.Int("run_steps", 100, "Number of steps performed when pressing N")
+	renderEvery = flag.Int("render_every", 10, "Steps between frames at max speed")
+
+	historyLen = flag.Int("history", 500, "Number of past steps kept for scrubbing")
+	keyEvery   = flag.Int("keyframe", 50, "Steps between full copies of weights in history")
+)
+
+// Size of the plot image and number of steps shown
+const (
+	plotWidth  = 400
+	plotHeight = 600
+	plotLength = 400
 )
 
 var (
-	lastHitX       float32
-	lastHitY       float32
-	lastHit        bool
-	bindInput      bool
-	updateRequest  bool
-	updateInterval time.Duration = 2 * time.Second
+	lastHitX      float32
+	lastHitY      float32
+	lastHit       bool
+	bindInput     bool
+	bindPattern   bool
+	hudRequest    bool
+	toggleHUD     bool
+	togglePlot    bool
+	probeRequest  bool
+	unbindRequest bool
+	graphRequest  bool
+
+	// Simulation speed and snapshots
+	ctl             *Controller
+	snapshotRequest bool
+	rewindRequest   bool
+
+	// Editing tools
+	editor   *Editor
+	painting bool
+	erasing  bool
+
+	// Scrubbing through history
+	scrubDelta  int
+	scrubToLive bool
+
+	// View navigation
+	dragging     bool
+	dragX, dragY float32
+	panX, panY   float32
+	zoomFactor   float32 = 1.0
+	zoomX, zoomY float32
+	resetView    bool
+
+	// Cell under the cursor
+	hoverX, hoverY float32
+	hoverMoved     bool
+
+	// Window resizing
+	resizeRequest bool
+	fbWidth       int
+	fbHeight      int
 )
 
+// Converts cursor position to framebuffer pixels
+func cursorToPixels(w *glfw.Window, x, y float64) (float32, float32) {
+	ww, wh := w.GetSize()
+	fw, fh := w.GetFramebufferSize()
+	return float32(x * float64(fw) / float64(ww)), float32(y * float64(fh) / float64(wh))
+}
+
 func myMouse(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mod glfw.ModifierKey) {
+	if button == glfw.MouseButtonMiddle {
+		// Middle button drags the view
+		dragging = action == glfw.Press
+		x, y := w.GetCursorPos()
+		dragX, dragY = cursorToPixels(w, x, y)
+		return
+	}
+	if button == glfw.MouseButtonLeft && action == glfw.Release {
+		painting = false
+	}
 	if action == glfw.Press {
 		x, y := w.GetCursorPos()
-		w, h := w.GetSize()
-		rx, ry := 2.0*x/float64(w)-1.0, 2.0*(float64(h)-y)/float64(h)-1.0
-		lastHitX = float32(rx) * float32(*width) / float32(*height)
-		lastHitY = float32(ry)
+		lastHitX, lastHitY = cursorToPixels(w, x, y)
 		lastHit = true
 		if button == glfw.MouseButtonLeft {
 			log.Println("Click in position", lastHitX, lastHitY)
+			// Keep painting while dragging, shift erases
+			painting = true
+			erasing = mod&glfw.ModShift != 0
 		} else if button == glfw.MouseButtonRight {
 			log.Println("Binding input data to", lastHitX, lastHitY)
 			bindInput = true
+			bindPattern = mod&glfw.ModShift != 0
 		}
 	}
 }
 
+func myCursor(w *glfw.Window, x, y float64) {
+	hoverX, hoverY = cursorToPixels(w, x, y)
+	hoverMoved = true
+	if painting && editor.Tool != ToolWeight {
+		lastHitX, lastHitY = hoverX, hoverY
+		lastHit = true
+	}
+	if dragging {
+		sx, sy := cursorToPixels(w, x, y)
+		panX += sx - dragX
+		panY += sy - dragY
+		dragX, dragY = sx, sy
+	}
+}
+
+func myScroll(w *glfw.Window, xoff, yoff float64) {
+	// Each wheel step zooms by 10%, centered on the cursor
+	x, y := w.GetCursorPos()
+	zoomX, zoomY = cursorToPixels(w, x, y)
+	zoomFactor *= float32(math.Pow(1.1, yoff))
+}
+
+func myResize(w *glfw.Window, width, height int) {
+	// Minimized windows report a null size
+	if width > 0 && height > 0 {
+		fbWidth, fbHeight = width, height
+		resizeRequest = true
+	}
+}
+
 func myKey(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
 	if action == glfw.Press && key == glfw.KeySpace {
-		updateRequest = true
+		ctl.TogglePause()
+		hudRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyS {
+		ctl.Run(1)
+		hudRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyN {
+		ctl.Run(*runSteps)
+		hudRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyM {
+		ctl.ToggleMaxSpeed()
+		hudRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyC {
+		snapshotRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyR {
+		rewindRequest = true
 	}
 	if action == glfw.Press && key == glfw.KeyQ {
-		updateInterval /= 2
-		log.Println("Update interval changed to", updateInterval)
+		ctl.Faster()
+		hudRequest = true
 	}
 	if action == glfw.Press && key == glfw.KeyA {
-		updateInterval *= 2
-		log.Println("Update interval changed to", updateInterval)
+		ctl.Slower()
+		hudRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyZ {
+		resetView = true
+	}
+	if (action == glfw.Press || action == glfw.Repeat) && key == glfw.KeyLeft {
+		scrubDelta--
+	}
+	if (action == glfw.Press || action == glfw.Repeat) && key == glfw.KeyRight {
+		scrubDelta++
+	}
+	if action == glfw.Press && key == glfw.KeyEnd {
+		scrubToLive = true
+	}
+	if action == glfw.Press && key == glfw.KeyU {
+		unbindRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyE {
+		editor.NextTool()
+		hudRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyLeftBracket {
+		editor.Grow(-1)
+		hudRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyRightBracket {
+		editor.Grow(1)
+		hudRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyH {
+		toggleHUD = true
+	}
+	if action == glfw.Press && key == glfw.KeyG {
+		togglePlot = true
+	}
+	if action == glfw.Press && key == glfw.KeyP {
+		probeRequest = true
+	}
+	if action == glfw.Press && key == glfw.KeyW {
+		graphRequest = true
+	}
+}
+
+// Describes the state of cell (x,y): value, threshold and contact weights
+func cellInfo(g *HexGrid, x, y int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "(%d,%d) %v value %.3f threshold %.3f weights", x, y, g.GetType(x, y), g.Get(x, y), g.GetT(x, y))
+	for _, w := range g.ContactWeights(x, y) {
+		fmt.Fprintf(&sb, " %.2f", w)
+	}
+	return sb.String()
+}
+
+// Text shown in the HUD, viewing is the step shown (-1 when live)
+func hudText(g *HexGrid, m *Metrics, steps, viewing int) []string {
+	st := g.Stats()
+	view := "live"
+	if viewing >= 0 {
+		view = fmt.Sprintf("step %d (history)", viewing)
+	}
+	return append([]string{
+		fmt.Sprintf("Step      %d (%v)", steps, ctl.Mode),
+		fmt.Sprintf("Interval  %v", ctl.Interval),
+		fmt.Sprintf("Viewing   %s", view),
+		fmt.Sprintf("Tool      %v (radius %d)", editor.Tool, editor.Radius),
+		fmt.Sprintf("Mean act. %.3f", st.MeanValue),
+		fmt.Sprintf("Firing    %.1f%%", 100*st.Firing),
+		fmt.Sprintf("Mean wei. %.3f", st.MeanWeight),
+	}, m.Report(g)...)
+}
+
+// A cell whose value is plotted over time
+type trace struct {
+	x, y int
+	s    *Series
+}
+
+// Returns the position of the trace of cell (x,y), or -1 if not traced
+func traceIndex(traces []trace, x, y int) int {
+	for i, t := range traces {
+		if t.x == x && t.y == y {
+			return i
+		}
 	}
+	return -1
 }
 
-func readFloats(path string) []float32 {
-	bytes, err := ioutil.ReadFile(path)
+// Options for the structure of the grid given by flags
+func topology() []GridOption {
+	bound, err := Boundary(*boundary)
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
-	fields := strings.Fields(string(bytes))
-	data := make([]float32, len(fields))
-	for i, s := range fields {
-		val, err := strconv.ParseFloat(s, 32)
+	opts := []GridOption{bound}
+	if *directed {
+		opts = append(opts, Directed())
+	}
+	opts = append(opts, Radius(*radius), InhibitoryFraction(*inhibit))
+	return opts
+}
+
+// Runs headless simulations for every combination of the ranges
+func runSweep(specs []string, config *Config) {
+	if len(specs) == 0 || *maxSteps <= 0 {
+		log.Fatalln("Sweeps need -steps and at least a parameter range")
+	}
+	if *sweepJobs < 1 {
+		log.Fatalln("Sweeps need -jobs of at least 1")
+	}
+	sw := &Sweep{Base: config.Params, Steps: *maxSteps, Jobs: *sweepJobs}
+	for _, spec := range specs {
+		r, err := ParseRange(spec)
 		if err != nil {
-			log.Println("Conversion impossible for datum", s)
-		} else {
-			data[i] = float32(val)
+			log.Fatalln(err)
+		}
+		sw.Ranges = append(sw.Ranges, r)
+	}
+	base := *seed
+	if base == 0 {
+		base = 1
+	}
+	for i := 0; i < *sweepSeeds; i++ {
+		sw.Seeds = append(sw.Seeds, base+int64(i))
+	}
+	topo := topology()
+	mode, err := ParsePlayMode(*playback)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	sw.Grid = func(p Params, s int64) *HexGrid {
+		// Runs are concurrent, each needs its own options
+		opts := append(append([]GridOption(nil), topo...), WithParams(p), Seed(s))
+		g := NewGrid(*cols, *rows, opts...)
+		if err := config.Setup(g, *inputRate, mode, *rateDiv); err != nil {
+			log.Fatalln("Invalid config:", err)
+		}
+		return g
+	}
+
+	out := os.Stdout
+	if *sweepOut != "" {
+		var err error
+		if out, err = os.Create(*sweepOut); err != nil {
+			log.Fatalln(err)
 		}
+		defer out.Close()
+	}
+	start := time.Now()
+	results := sw.Run()
+	log.Println("Sweep of", len(results)*len(sw.Seeds), "runs done in", time.Since(start))
+	if err := sw.Write(out, results); err != nil {
+		log.Fatalln("Cannot write sweep:", err)
 	}
-	return data
 }
 
 func main() {
 	// OpenGL context is bound to a CPU thread
 	runtime.LockOSThread()
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [replay trace_file | export trace_file out.npz | sweep name=from:to:step|name=v1,v2 ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	// Load data file
-	var inData []float32
+	// Experiment file provides the values of flags not given
+	config := &Config{Params: DefaultParams()}
+	if *configPath != "" {
+		var err error
+		if config, err = LoadConfig(*configPath); err != nil {
+			log.Fatalln("Cannot load config:", err)
+		}
+		if err := config.SetFlags(); err != nil {
+			log.Fatalln("Invalid config:", err)
+		}
+	}
+	if *rule != "" {
+		config.Params.Rule = *rule
+	}
+	if err := config.Params.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+	if *historyLen < 1 {
+		log.Fatalln("-history must be at least 1")
+	}
+	if *renderEvery < 1 {
+		log.Fatalln("-render_every must be at least 1")
+	}
+
+	// Replaying a trace, the grid is read from file instead of computed
+	var replay *TraceReader
+	switch flag.Arg(0) {
+	case "":
+	case "replay":
+		f, err := os.Open(flag.Arg(1))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		if replay, err = NewTraceReader(f); err != nil {
+			log.Fatalln("Cannot read trace:", err)
+		}
+		*rows, *cols = replay.Header.H, replay.Header.W
+		*seed = replay.Header.Seed
+		*directed = replay.Header.Directed
+		*radius = replay.Header.Radius
+		if *radius == 0 {
+			*radius = 1 // Traces recorded before radius was configurable
+		}
+	case "export":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := ExportTrace(flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Fatalln("Cannot export trace:", err)
+		}
+		return
+	case "sweep":
+		runSweep(flag.Args()[1:], config)
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load data file, channels are bound in turn with right clicks
+	var input *Input
+	nextChannel := 0
 	if *inPath != "" {
-		inData = readFloats(*inPath)
+		var err error
+		if input, err = LoadInput(*inPath, *inputRate); err != nil {
+			log.Fatalln("Cannot load input data:", err)
+		}
+		log.Println("Loaded input channels", input.Names)
+	}
+	mode, err := ParsePlayMode(*playback)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Load the spatial pattern
+	var pattern *Pattern
+	shape, err := ParseShape(*patternShape)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *patternSpec != "" {
+		if pattern, err = ParsePattern(*patternSpec); err != nil {
+			log.Fatalln("Cannot load pattern:", err)
+		}
+		log.Println("Loaded pattern", pattern.W, "x", pattern.H, "with", len(pattern.Frames), "frames")
+	}
+
+	gridOpts := append(topology(), WithParams(config.Params))
+	if *seed != 0 {
+		gridOpts = append(gridOpts, Seed(*seed))
+	}
+	if replay != nil {
+		gridOpts = append(gridOpts, WithParams(replay.Header.Params))
+	}
+	grid := NewGrid(*cols, *rows, gridOpts...)
+	log.Println("Grid seed", grid.Seed)
+	if replay != nil {
+		if err := replay.Header.SetTypes(grid); err != nil {
+			log.Fatalln("Cannot read trace:", err)
+		}
+		// Recorded weights replace the ones of the grid
+		if replay.Header.Weights && replay.Header.WLen != len(grid.WData) {
+			log.Fatalln("Cannot read trace:", replay.Header.WLen, "weights for a grid with", len(grid.WData))
+		}
+	}
+	if err := config.Setup(grid, *inputRate, mode, *rateDiv); err != nil {
+		log.Fatalln("Invalid config:", err)
+	}
+
+	// Layers stacked over the grid, which is the first one
+	stack := NewStack(grid)
+	if *layers != "" && replay != nil {
+		log.Println("Only the first layer is replayed")
+	} else if *layers != "" {
+		sizes, err := ParseLayers(*layers)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for i, sz := range sizes {
+			opts := append(topology(), WithParams(grid.P), Seed(grid.Seed+int64(i)+1))
+			stack.Layers = append(stack.Layers, NewGrid(sz[1], sz[0], opts...))
+		}
+		if len(config.Links) > 0 {
+			for _, ln := range config.Links {
+				if err := stack.Connect(ln); err != nil {
+					log.Fatalln("Invalid config:", err)
+				}
+			}
+		} else {
+			stack.ConnectLayers(float32(*feedforward), float32(*feedback), *field)
+		}
+	}
+	ctl = NewController(2*time.Second, *renderEvery)
+	editor = NewEditor()
+
+	// Moves the grid forward by one step, false if it is not possible
+	steps := 0
+	advance := func() bool {
+		if *maxSteps > 0 && steps >= *maxSteps {
+			log.Println("Reached", steps, "steps")
+			ctl.Mode = Paused
+			return false
+		}
+		if replay == nil {
+			stack.Update()
+			steps++
+			return true
+		}
+		f, err := replay.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Cannot read trace:", err)
+			}
+			log.Println("End of trace")
+			ctl.Mode = Paused
+			return false
+		}
+		// Parts not recorded keep their last value
+		grid.Data = f.Data
+		if f.WData != nil {
+			grid.WData = f.WData
+		}
+		if f.Thres != nil {
+			grid.Thres = f.Thres
+		}
+		steps = f.Step
+		return true
+	}
+	if replay != nil {
+		advance()
+	}
+
+	// Record the run, starting from the initial state
+	var recorder *TraceWriter
+	if *recordPath != "" && replay == nil {
+		f, err := os.Create(*recordPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		if recorder, err = NewTraceWriter(f, grid, *recordWeights, *recordThres, *traceChunk); err != nil {
+			log.Fatalln("Cannot write trace:", err)
+		}
+		defer recorder.Close()
+		recorder.Write(steps, grid)
+	}
+
+	// Whole run kept in memory, to be saved on exit
+	var exporter *Exporter
+	export := func() {
+		// Arrays not in the trace replayed are left out
+		wdata, thres := grid.WData, grid.Thres
+		if replay != nil && !replay.Header.Weights {
+			wdata = nil
+		}
+		if replay != nil && !replay.Header.Thres {
+			thres = nil
+		}
+		exporter.Add(steps, grid.Data, wdata, thres)
+	}
+	if *exportPath != "" {
+		exporter = NewExporter(grid.W, grid.H, len(grid.WData))
+		export()
+	}
+	// Statistics over the whole run, shown in the HUD. Avalanches are
+	// saved on exit with -avalanches
+	metrics := NewMetrics()
+	metrics.Observe(steps, grid)
+	cycled := false
+
+	finish := func() {
+		if exporter != nil {
+			if err := exporter.Write(*exportPath); err != nil {
+				log.Fatalln("Cannot export:", err)
+			}
+			log.Println("Exported", len(exporter.steps), "steps to", *exportPath)
+		}
+		if *avalanchePath != "" {
+			avalanches := metrics.Avalanches
+			avalanches.Flush()
+			f, err := os.Create(*avalanchePath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+			if err := avalanches.WriteCSV(f); err != nil {
+				log.Fatalln("Cannot write avalanches:", err)
+			}
+			log.Println("Saved", len(avalanches.Done), "avalanches to", *avalanchePath)
+		}
+		if *graphPath != "" {
+			if err := ExportGraph(*graphPath, grid); err != nil {
+				log.Fatalln("Cannot save graph:", err)
+			}
+			log.Println("Saved graph of weights to", *graphPath)
+		}
+	}
+	// Saves the new state
+	store := func() {
+		if cycled = metrics.Observe(steps, grid); cycled {
+			log.Println("Values repeating,", metrics.StateCycle())
+		}
+		if recorder != nil {
+			if err := recorder.Write(steps, grid); err != nil {
+				log.Fatalln("Cannot write trace:", err)
+			}
+		}
+		if exporter != nil {
+			export()
+		}
+	}
+
+	if *headless {
+		if *maxSteps <= 0 && replay == nil {
+			log.Fatalln("Headless runs need -steps")
+		}
+		// Probe values as tab separated columns
+		fmt.Print("step")
+		for _, p := range config.Probes {
+			fmt.Printf("\tprobe(%d,%d)", p.X, p.Y)
+		}
+		fmt.Println()
+		for advance() {
+			store()
+			fmt.Print(steps)
+			for _, p := range config.Probes {
+				fmt.Print("\t", grid.Get(p.X, p.Y))
+			}
+			fmt.Println()
+			if cycled && *stopCycle {
+				break
+			}
+		}
+		for _, l := range metrics.Report(grid) {
+			log.Println(l)
+		}
+		finish()
+		return
 	}
 
 	// Create a window for OpenGL
 	win := glad.NewOGLWindow(int(*width), int(*height), "Gex",
 		glad.CoreProfile(true),
-		glad.Resizable(false),
+		glad.Resizable(true),
 		glad.ContextVersion(4, 4),
 		//glad.VSync(true),
 	)
@@ -105,56 +670,331 @@ func main() {
 
 	win.SetMouseButtonCallback(myMouse)
 	win.SetKeyCallback(myKey)
+	win.SetCursorPosCallback(myCursor)
+	win.SetScrollCallback(myScroll)
+	win.SetFramebufferSizeCallback(myResize)
 
-	state := SetupOGL(*rows, *cols, float32(*width)/float32(*height))
+	// Framebuffer may differ from window size on high DPI screens
+	fbWidth, fbHeight = win.GetFramebufferSize()
+	state := SetupOGL(*rows, *cols, fbWidth, fbHeight, grid.Directed)
 
-	grid := NewGrid(*cols, *rows)
+	// Upper layers are shown at the right of the grid
+	views := []*ViewState{state}
+	for _, l := range stack.Layers[1:] {
+		views = append(views, SetupOGL(l.H, l.W, fbWidth, fbHeight, l.Directed))
+	}
+	// Returns the view containing pixel (px,py), -1 if none
+	viewAt := func(px, py float32) int {
+		for i, v := range views {
+			if v.Contains(px, py) {
+				return i
+			}
+		}
+		return -1
+	}
 
-	start := time.Now()
+	// Rewinding with R goes back to the last snapshot taken with C
+	snapshot, snapSteps := stack.Clone(), 0
+
+	// Overlay with statistics, toggled with H
+	hud := NewHUD(len(hudText(grid, metrics, steps, -1)))
+	hud.SetText(hudText(grid, metrics, steps, -1))
 
-	state.SetColors(grid.Data)
-	state.SetWeights(grid.WData)
+	// Past states, scrubbed with arrow keys while the simulation goes on
+	hist := NewHistory(*historyLen, *keyEvery)
+	hist.Push(steps, grid)
+	viewing := -1 // Step shown, -1 for the live grid
+
+	// Statistics and traces plotted next to the grid, toggled with G
+	plot := NewPlot(plotWidth, plotHeight, "Activity", "Learning", "Traces")
+	firing := plot.Panels[0].Add("firing", plotLength)
+	meanThres := plot.Panels[1].Add("thres", plotLength)
+	meanWeight := plot.Panels[1].Add("weight", plotLength)
+	var layerFiring []*Series
+	for i := range stack.Layers[1:] {
+		layerFiring = append(layerFiring, plot.Panels[0].Add(fmt.Sprintf("firing L%d", i+1), plotLength))
+	}
+	var traces []trace
+	for _, p := range config.Probes {
+		s := plot.Panels[2].Add(fmt.Sprintf("probe(%d,%d)", p.X, p.Y), plotLength)
+		traces = append(traces, trace{p.X, p.Y, s})
+	}
+	// Bound cells are traced as well, regions at the cell they are anchored to
+	traceInput := func(x, y int) {
+		if traceIndex(traces, x, y) < 0 {
+			s := plot.Panels[2].Add(fmt.Sprintf("in(%d,%d)", x, y), plotLength)
+			traces = append(traces, trace{x, y, s})
+		}
+	}
+	for _, b := range config.Bindings {
+		traceInput(b.X, b.Y)
+	}
+	plotStats := func() {
+		st := grid.Stats()
+		firing.Add(st.Firing)
+		meanThres.Add(st.MeanThreshold)
+		meanWeight.Add(st.MeanWeight)
+		for i, s := range layerFiring {
+			s.Add(stack.Layers[i+1].Stats().Firing)
+		}
+		for _, t := range traces {
+			t.s.Add(grid.Get(t.x, t.y))
+		}
+	}
+
+	// Grid and plot share the window
+	layout := func() {
+		gw := fbWidth
+		if plot.Visible {
+			gw = fbWidth * 2 / 3
+			plot.PlaceRect(gw, 0, fbWidth-gw, fbHeight, fbWidth, fbHeight)
+		}
+		// Layers split the area of the grid
+		lw := gw / len(views)
+		for i, v := range views {
+			v.Resize(lw, fbHeight)
+			v.Place(i*lw, 0, fbWidth, fbHeight)
+		}
+		hud.Place(hudMargin, hudMargin, fbWidth, fbHeight)
+	}
+	layout()
+	plotStats()
+	plot.Render()
+
+	// Cell currently under the cursor, and its layer
+	hx, hy, hl := -1, -1, -1
+	showHover := func() {
+		if hx < 0 {
+			win.SetTitle("Gex")
+		} else if len(views) > 1 {
+			win.SetTitle(fmt.Sprintf("Gex - layer %d %s", hl, cellInfo(stack.Layers[hl], hx, hy)))
+		} else {
+			win.SetTitle("Gex - " + cellInfo(grid, hx, hy))
+		}
+	}
+
+	// Sends the shown state to the view
+	refresh := func() {
+		if i := hist.Find(viewing); viewing >= 0 && i >= 0 {
+			_, data, weights, _ := hist.Frame(i)
+			state.SetColors(data)
+			state.SetWeights(grid.RingWeights(weights))
+		} else {
+			viewing = -1
+			state.SetColors(grid.Data)
+			state.SetWeights(grid.RingWeights(grid.WData))
+		}
+		state.SetKinds(grid.TypeValues())
+		// History covers the first layer only
+		for i, l := range stack.Layers[1:] {
+			views[i+1].SetColors(l.Data)
+			views[i+1].SetWeights(l.RingWeights(l.WData))
+			views[i+1].SetKinds(l.TypeValues())
+		}
+	}
+	refresh()
 
 	// Main loop
 	for !win.ShouldClose() {
+		// Rebuild layout after the window changed size
+		if resizeRequest {
+			resizeRequest = false
+			layout()
+			hoverMoved = true
+		}
+
+		// Apply view navigation
+		// Pan and zoom act on the view under the cursor
+		if resetView {
+			resetView = false
+			for _, v := range views {
+				v.ResetView()
+			}
+			hoverMoved = true
+		}
+		if panX != 0 || panY != 0 {
+			if i := viewAt(hoverX, hoverY); i >= 0 {
+				views[i].Pan(views[i].PixelsToScreen(panX, panY))
+			}
+			panX, panY = 0, 0
+			hoverMoved = true
+		}
+		if zoomFactor != 1.0 {
+			if i := viewAt(zoomX, zoomY); i >= 0 {
+				zx, zy := views[i].FromPixels(zoomX, zoomY)
+				views[i].Zoom(zoomFactor, zx, zy)
+			}
+			zoomFactor = 1.0
+			hoverMoved = true
+		}
+
 		// Check if there were mouse click
 		if lastHit {
 			lastHit = false // Reset state
-			nx, ny := state.NearestVertex(lastHitX, lastHitY)
-			if bindInput {
-				grid.Bind(nx, ny, inData)
+			nx, ny := -1, -1
+			if state.Contains(lastHitX, lastHitY) {
+				nx, ny = state.NearestVertex(state.FromPixels(lastHitX, lastHitY))
+			}
+			if nx < 0 || (bindInput && !bindPattern && input == nil) || (bindInput && bindPattern && pattern == nil) {
+				// Clicked outside the grid, or nothing to bind
+				bindInput = false
+			} else if bindInput && bindPattern {
+				grid.BindRegion(nx, ny, pattern, shape, Playback(mode), Every(*rateDiv))
+				traceInput(nx, ny)
 				bindInput = false
+			} else if bindInput {
+				log.Println("Binding channel", input.Names[nextChannel])
+				grid.Bind(nx, ny, input.Channels[nextChannel], Playback(mode), Every(*rateDiv))
+				nextChannel = (nextChannel + 1) % len(input.Channels)
+				traceInput(nx, ny)
+				bindInput = false
+			} else if editor.Tool == ToolWeight && editor.Radius == 0 {
+				// Edge between the clicked cell and the closest neighbour
+				ex, ey, k := state.NearestEdge(state.FromPixels(lastHitX, lastHitY))
+				editor.ApplyEdge(grid, ex, ey, k, erasing)
 			} else {
-				// Get nearest vertex of the grid
-				grid.Set(nx, ny, 1.0) //-grid.Get(nx, ny)) // Toggle value
-				//grid.SetW(nx, ny, 0, 0.5)  //1.0-grid.Get(nx, ny))
-				//grid.SetW(nx, ny, 1, 0.75) //1.0-grid.Get(nx, ny))
-				//grid.SetW(nx, ny, 2, 1.0)  //-grid.Get(nx, ny))
-				//state.SetWeights(grid.WData)
+				editor.Apply(grid, nx, ny, erasing)
 			}
-			state.SetColors(grid.Data)
+			refresh()
+			showHover()
+			hudRequest = true
 		}
 
-		state.DrawFrame()
-
-		win.SwapBuffers()
-		glfw.PollEvents()
+		// Track the cell under the cursor
+		if hoverMoved {
+			hoverMoved = false
+			nx, ny, nl := -1, -1, viewAt(hoverX, hoverY)
+			if nl >= 0 {
+				nx, ny = views[nl].NearestVertex(views[nl].FromPixels(hoverX, hoverY))
+			}
+			if nx != hx || ny != hy || nl != hl {
+				if hl >= 0 {
+					views[hl].SetHighlight(-1, -1)
+				}
+				hx, hy, hl = nx, ny, nl
+				if hl >= 0 {
+					views[hl].SetHighlight(hx, hy)
+				}
+				showHover()
+			}
+		}
 
-		if updateRequest {
-			// Update world step
-			grid.Update()
-			state.SetColors(grid.Data)
-			state.SetWeights(grid.WData)
-			updateRequest = false
+		if toggleHUD {
+			toggleHUD = false
+			hud.Visible = !hud.Visible
+		}
+		if togglePlot {
+			togglePlot = false
+			plot.Visible = !plot.Visible
+			layout()
+			plot.Render()
+			hoverMoved = true
+		}
+		if probeRequest {
+			probeRequest = false
+			// Probes are on the first layer only
+			if i := traceIndex(traces, hx, hy); hl == 0 && i >= 0 {
+				plot.Panels[2].Remove(traces[i].s.Name)
+				traces = append(traces[:i], traces[i+1:]...)
+			} else if hl == 0 && hx >= 0 {
+				s := plot.Panels[2].Add(fmt.Sprintf("probe(%d,%d)", hx, hy), plotLength)
+				traces = append(traces, trace{hx, hy, s})
+			}
+			plot.Render()
+		}
+		if graphRequest {
+			graphRequest = false
+			path := *graphPath
+			if path == "" {
+				path = fmt.Sprintf("weights_%d.graphml", steps)
+			}
+			if err := ExportGraph(path, grid); err != nil {
+				log.Println("Cannot save graph:", err)
+			} else {
+				log.Println("Saved graph of weights to", path)
+			}
+		}
+		if snapshotRequest {
+			snapshotRequest = false
+			snapshot, snapSteps = stack.Clone(), steps
+			log.Println("Snapshot taken at step", steps)
+		}
+		if rewindRequest && replay != nil {
+			rewindRequest = false
+			log.Println("Cannot rewind while replaying")
+		}
+		if rewindRequest {
+			rewindRequest = false
+			stack.Restore(snapshot)
+			steps = snapSteps
+			log.Println("Rewind to step", steps)
+			hist.Clear()
+			hist.Push(steps, grid)
+			// Cascades going on do not continue in the restored state,
+			// completed ones are kept to be saved
+			avalanches := metrics.Avalanches
+			avalanches.Flush()
+			metrics.Reset()
+			metrics.Avalanches = avalanches
+			metrics.Observe(steps, grid)
+			refresh()
+			showHover()
+			hudRequest = true
+		}
+		if scrubDelta != 0 || scrubToLive {
+			if viewing < 0 {
+				viewing = steps
+			}
+			viewing += scrubDelta
+			if oldest, _, _, _ := hist.Frame(0); viewing < oldest {
+				viewing = oldest
+			}
+			if viewing >= steps || scrubToLive {
+				viewing = -1
+			}
+			scrubDelta, scrubToLive = 0, false
+			refresh()
+			hudRequest = true
+		}
+		if unbindRequest {
+			unbindRequest = false
+			if hl == 0 && hx >= 0 && grid.Unbind(hx, hy) {
+				log.Println("Unbound cell", hx, hy)
+			}
+		}
+		if hudRequest {
+			hudRequest = false
+			hud.SetText(hudText(grid, metrics, steps, viewing))
 		}
 
-		if time.Since(start) > updateInterval {
-			// Save new time
-			start = time.Now()
-			updateRequest = true
+		for _, v := range views {
+			v.DrawFrame()
+		}
+		if plot.Visible {
+			plot.Draw()
 		}
+		hud.Draw()
 
+		win.SwapBuffers()
+		glfw.PollEvents()
+
+		if n := ctl.Steps(time.Now()); n > 0 {
+			// Update world, rendering only the last step
+			for i := 0; i < n && advance(); i++ {
+				hist.Push(steps, grid)
+				store()
+				plotStats()
+			}
+			if plot.Visible {
+				plot.Render()
+			}
+			hudRequest = true
+			refresh()
+			showHover()
+		}
 	}
+
+	finish()
 }
 
 /*
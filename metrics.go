@@ -0,0 +1,186 @@
+package main
+
+// Statistics of network dynamics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// Longest period searched in firing patterns and states
+const maxPeriod = 1000
+
+// Fraction of cells above the activation threshold
+func FiringRate(g *HexGrid) float64 {
+	return float64(g.Stats().Firing)
+}
+
+func firing(g *HexGrid, i int) bool {
+	return g.Data[i] > g.P.ActivationThreshold
+}
+
+// Moran's I of the firing pattern over the hexagonal neighbourhood:
+// positive when active cells are close to each other, about zero when
+// activity is scattered at random, 0 when all cells are in the same state
+func MoranI(g *HexGrid) float64 {
+	n := len(g.Data)
+	var mean float64
+	for i := range g.Data {
+		if firing(g, i) {
+			mean++
+		}
+	}
+	mean /= float64(n)
+	dev := func(i int) float64 {
+		if firing(g, i) {
+			return 1 - mean
+		}
+		return -mean
+	}
+
+	var num, den, w float64
+	for y := 0; y < g.H; y++ {
+		for x := 0; x < g.W; x++ {
+			i := y*g.W + x
+			d := dev(i)
+			den += d * d
+			for _, nb := range nbors {
+				nx, ny := g.wrap(x+nb.x_, y+nb.y_)
+				if j := ny*g.W + nx; j != i {
+					num += d * dev(j)
+					w++
+				}
+			}
+		}
+	}
+	if den == 0 || w == 0 {
+		return 0
+	}
+	return float64(n) / w * num / den
+}
+
+// Counts weights in bins of equal width in [0,1], values out of the range
+// are counted in the first or last bin
+func WeightHistogram(g *HexGrid, bins int) []int {
+	h := make([]int, bins)
+	for _, w := range g.WData {
+		b := int(w * float32(bins))
+		if b < 0 {
+			b = 0
+		} else if b >= bins {
+			b = bins - 1
+		}
+		h[b]++
+	}
+	return h
+}
+
+// Accumulates statistics of a grid over time
+type Metrics struct {
+	Steps      int
+	Avalanches *AvalancheTracker // Cascades of firing
+	Pattern    *CycleDetector    // Repetitions of the firing pattern
+	State      *CycleDetector    // Repetitions of the exact values
+
+	fires []int // Steps each cell was firing
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	m.Reset()
+	return m
+}
+
+// Forgets everything observed
+func (m *Metrics) Reset() {
+	*m = Metrics{
+		Avalanches: NewAvalancheTracker(),
+		Pattern:    NewCycleDetector(maxPeriod),
+		State:      NewCycleDetector(maxPeriod),
+	}
+}
+
+// Adds the state of the grid at given step, true when the values enter
+// a fixed point or a cycle
+func (m *Metrics) Observe(step int, g *HexGrid) bool {
+	if len(m.fires) != len(g.Data) {
+		if m.Steps > 0 {
+			// A different grid
+			m.Reset()
+		}
+		m.fires = make([]int, len(g.Data))
+	}
+	m.Steps++
+
+	h := fnv.New64a()
+	bits := make([]byte, (len(g.Data)+7)/8)
+	for i := range g.Data {
+		if firing(g, i) {
+			m.fires[i]++
+			bits[i/8] |= 1 << uint(i%8)
+		}
+	}
+	h.Write(bits)
+
+	m.Avalanches.Observe(step, g)
+	m.Pattern.Observe(step, h.Sum64())
+	return m.State.Observe(step, hashData(g.Data))
+}
+
+// Mean over cells of the entropy of their firing, in bits: 0 for cells
+// always or never firing, 1 for cells firing half of the time
+func (m *Metrics) Entropy() float64 {
+	if m.Steps == 0 {
+		return 0
+	}
+	var e float64
+	for _, f := range m.fires {
+		p := float64(f) / float64(m.Steps)
+		if p > 0 && p < 1 {
+			e -= p*math.Log2(p) + (1-p)*math.Log2(1-p)
+		}
+	}
+	return e / float64(len(m.fires))
+}
+
+// Describes the metrics, one per line
+func (m *Metrics) Report(g *HexGrid) []string {
+	period := "none"
+	if m.Pattern.Period > 0 {
+		period = fmt.Sprintf("%d steps", m.Pattern.Period)
+	}
+	done := m.Avalanches.Done
+	var size float64
+	for _, a := range done {
+		size += float64(a.Size)
+	}
+	if len(done) > 0 {
+		size /= float64(len(done))
+	}
+	// Percentages fit in the HUD better than counts
+	hist := make([]string, 0, 5)
+	for _, n := range WeightHistogram(g, 5) {
+		hist = append(hist, fmt.Sprint(100*n/len(g.WData)))
+	}
+	return []string{
+		fmt.Sprintf("Moran's I %.3f", MoranI(g)),
+		fmt.Sprintf("Entropy   %.3f bits", m.Entropy()),
+		fmt.Sprintf("Period    %s", period),
+		fmt.Sprintf("State     %s", m.StateCycle()),
+		fmt.Sprintf("Avalanch. %d (mean size %.1f)", len(done), size),
+		fmt.Sprintf("Weights%%  %s", strings.Join(hist, " ")),
+	}
+}
+
+// Describes the cycle of the exact values
+func (m *Metrics) StateCycle() string {
+	switch m.State.Period {
+	case 0:
+		return "not repeating"
+	case 1:
+		return fmt.Sprintf("fixed point since %d", m.State.Start)
+	}
+	return fmt.Sprintf("period %d since %d", m.State.Period, m.State.Start)
+}
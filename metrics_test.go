@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMoranI(t *testing.T) {
+	g := NewGrid(8, 8)
+	// Left half firing, right half silent
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			g.Set(x, y, 0)
+			if x < 4 {
+				g.Set(x, y, 1)
+			}
+		}
+	}
+	if i := MoranI(g); i < 0.3 {
+		t.Error("Clustered activity should be positively correlated", i)
+	}
+	// Alternating rows: every cell has 2 of 6 neighbours in the same state
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			g.Set(x, y, float32(y%2))
+		}
+	}
+	if i := MoranI(g); i > -0.2 {
+		t.Error("Alternating activity should be negatively correlated", i)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	g := NewGrid(4, 4)
+	m := NewMetrics()
+	// Firing spreading to a neighbour, silence, then the same again
+	frames := [][]int{{0}, {0, 1}, {}, {0}, {0, 1}, {}}
+	for i, f := range frames {
+		for i := range g.Data {
+			g.Data[i] = 0
+		}
+		for _, i := range f {
+			g.Data[i] = 1
+		}
+		m.Observe(i, g)
+	}
+	if d := m.Avalanches.Done; len(d) != 2 || d[0].Size != 3 || d[0].Duration != 2 || d[1].Start != 3 {
+		t.Error("Wrong avalanches", d)
+	}
+	if m.Pattern.Period != 3 || m.State.Period != 3 || m.State.Start != 0 {
+		t.Error("Wrong period", m.Pattern.Period, m.State.Period, m.State.Start)
+	}
+	// Cell 0 fires 4 times out of 6, cell 1 twice, others never
+	want := -(4.0/6*math.Log2(4.0/6) + 2.0/6*math.Log2(2.0/6)) * 2 / 16
+	if e := m.Entropy(); math.Abs(e-want) > 1e-9 {
+		t.Error("Wrong entropy", e, want)
+	}
+}
+
+func TestCycleDetector(t *testing.T) {
+	c := NewCycleDetector(3)
+	// Enters a cycle of period 2, leaves it, then stops at a fixed point
+	states := []uint64{1, 2, 3, 2, 3, 2, 4, 5, 5, 5}
+	var entered []int
+	for i, s := range states {
+		if c.Observe(i, s) {
+			entered = append(entered, i)
+		}
+		if i == 5 && (c.Period != 2 || c.Start != 1) {
+			t.Error("Wrong cycle", c.Period, c.Start)
+		}
+	}
+	if len(entered) != 2 || entered[0] != 3 || entered[1] != 8 || c.Period != 1 {
+		t.Error("Wrong cycle detection", entered, c.Period)
+	}
+	// Older states are forgotten
+	c.Reset()
+	for i, s := range []uint64{1, 2, 3, 4, 1} {
+		c.Observe(i, s)
+	}
+	if c.Period != 0 {
+		t.Error("Cycles longer than window should not be detected")
+	}
+}
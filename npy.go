@@ -0,0 +1,204 @@
+package main
+
+// Reading and writing of NumPy .npy arrays
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	npyMagic = "\x93NUMPY"
+
+	// Limits on sizes read from files, checked before allocating
+	maxNpyHeader = 1 << 16
+	maxNpyCount  = math.MaxInt32
+)
+
+var (
+	npyDescr   = regexp.MustCompile(`'descr'\s*:\s*'([^']*)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order'\s*:\s*(True|False)`)
+	npyShape   = regexp.MustCompile(`'shape'\s*:\s*\(([^)]*)\)`)
+)
+
+// Reads an array from r, converting values to float32. The data is
+// returned in C order, whatever the order used in the file
+func ReadNpy(r io.Reader) (shape []int, data []float32, err error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 8)
+	if _, err = io.ReadFull(br, magic); err != nil {
+		return
+	}
+	if string(magic[:6]) != npyMagic {
+		return nil, nil, fmt.Errorf("not a npy file")
+	}
+	var hlen int
+	if magic[6] == 1 {
+		var l uint16
+		err = binary.Read(br, binary.LittleEndian, &l)
+		hlen = int(l)
+	} else {
+		var l uint32
+		err = binary.Read(br, binary.LittleEndian, &l)
+		hlen = int(l)
+	}
+	if err != nil {
+		return
+	}
+	if hlen > maxNpyHeader {
+		return nil, nil, fmt.Errorf("npy header too long: %d bytes", hlen)
+	}
+	hbuf := make([]byte, hlen)
+	if _, err = io.ReadFull(br, hbuf); err != nil {
+		return
+	}
+	header := string(hbuf)
+
+	m := npyDescr.FindStringSubmatch(header)
+	if m == nil {
+		return nil, nil, fmt.Errorf("npy header without descr: %s", header)
+	}
+	descr := m[1]
+	fortran := false
+	if m := npyFortran.FindStringSubmatch(header); m != nil {
+		fortran = m[1] == "True"
+	}
+	m = npyShape.FindStringSubmatch(header)
+	if m == nil {
+		return nil, nil, fmt.Errorf("npy header without shape: %s", header)
+	}
+	count := 1
+	for _, f := range strings.Split(m[1], ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 || (n > 0 && count > maxNpyCount/n) {
+			return nil, nil, fmt.Errorf("bad npy shape %q", m[1])
+		}
+		shape = append(shape, n)
+		count *= n
+	}
+
+	data, err = readNpyData(br, descr, count)
+	if err != nil {
+		return
+	}
+	if fortran && len(shape) > 1 {
+		data = fortranToC(shape, data)
+	}
+	return
+}
+
+func readNpyData(r io.Reader, descr string, count int) ([]float32, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if strings.HasPrefix(descr, ">") {
+		order = binary.BigEndian
+	}
+	kind := strings.TrimLeft(descr, "<>|=")
+	size := map[string]int{
+		"f4": 4, "f8": 8, "i1": 1, "u1": 1, "i2": 2, "u2": 2, "i4": 4, "u4": 4, "i8": 8, "u8": 8, "b1": 1,
+	}[kind]
+	if size == 0 {
+		return nil, fmt.Errorf("unsupported npy dtype %q", descr)
+	}
+	// Data grows as it is read, the shape could be wrong
+	buf, err := io.ReadAll(io.LimitReader(r, int64(size)*int64(count)))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < size*count {
+		return nil, fmt.Errorf("npy data truncated: %d of %d bytes", len(buf), size*count)
+	}
+	data := make([]float32, count)
+	for i := range data {
+		b := buf[i*size : (i+1)*size]
+		switch kind {
+		case "f4":
+			data[i] = math.Float32frombits(order.Uint32(b))
+		case "f8":
+			data[i] = float32(math.Float64frombits(order.Uint64(b)))
+		case "i1":
+			data[i] = float32(int8(b[0]))
+		case "u1", "b1":
+			data[i] = float32(b[0])
+		case "i2":
+			data[i] = float32(int16(order.Uint16(b)))
+		case "u2":
+			data[i] = float32(order.Uint16(b))
+		case "i4":
+			data[i] = float32(int32(order.Uint32(b)))
+		case "u4":
+			data[i] = float32(order.Uint32(b))
+		case "i8":
+			data[i] = float32(int64(order.Uint64(b)))
+		case "u8":
+			data[i] = float32(order.Uint64(b))
+		}
+	}
+	return data, nil
+}
+
+// Reorders data stored with the first index changing fastest
+func fortranToC(shape []int, data []float32) []float32 {
+	out := make([]float32, len(data))
+	idx := make([]int, len(shape))
+	for i := range out {
+		// Position of the same element in Fortran order
+		f, stride := 0, 1
+		for d := range shape {
+			f += idx[d] * stride
+			stride *= shape[d]
+		}
+		out[i] = data[f]
+		// Next index in C order
+		for d := len(shape) - 1; d >= 0; d-- {
+			if idx[d]++; idx[d] < shape[d] {
+				break
+			}
+			idx[d] = 0
+		}
+	}
+	return out
+}
+
+// Writes an array with given shape, data must be []float32 or []int64
+func WriteNpy(w io.Writer, shape []int, data interface{}) error {
+	var descr string
+	switch data.(type) {
+	case []float32:
+		descr = "<f4"
+	case []int64:
+		descr = "<i8"
+	default:
+		return fmt.Errorf("unsupported npy data type %T", data)
+	}
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = strconv.Itoa(d)
+	}
+	sh := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		sh += ","
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, sh)
+	// Magic, version and length take 10 bytes, the total is padded to 64
+	pad := 64 - (10+len(header)+1)%64
+	header += strings.Repeat(" ", pad%64) + "\n"
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(npyMagic)
+	bw.Write([]byte{1, 0})
+	binary.Write(bw, binary.LittleEndian, uint16(len(header)))
+	bw.WriteString(header)
+	if err := binary.Write(bw, binary.LittleEndian, data); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
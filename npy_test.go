@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNpyRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	data := []float32{1, 2, 3, 4, 5, 6}
+	if err := WriteNpy(&buf, []int{2, 3}, data); err != nil {
+		t.Fatal(err)
+	}
+	if (bytes.IndexByte(buf.Bytes(), '\n')+1)%64 != 0 {
+		t.Error("Header should be aligned to 64 bytes")
+	}
+	shape, read, err := ReadNpy(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shape) != 2 || shape[0] != 2 || shape[1] != 3 {
+		t.Error("Wrong shape", shape)
+	}
+	for i := range data {
+		if read[i] != data[i] {
+			t.Error("Wrong data", read)
+		}
+	}
+
+	buf.Reset()
+	WriteNpy(&buf, []int{3}, []int64{7, 8, 9})
+	if shape, read, err = ReadNpy(&buf); err != nil || len(shape) != 1 || read[2] != 9 {
+		t.Error("Wrong int64 array", shape, read, err)
+	}
+}
+
+func TestNpyBadDtype(t *testing.T) {
+	for _, descr := range []string{"", "<c8"} {
+		if _, err := readNpyData(bytes.NewReader(nil), descr, 1); err == nil {
+			t.Errorf("Dtype %q should be rejected", descr)
+		}
+	}
+}
+
+func TestNpyBadShape(t *testing.T) {
+	for _, shape := range []string{"(-1,)", "(100000, 100000, 100000)", "(1000000000,)"} {
+		header := "{'descr': '<f4', 'fortran_order': False, 'shape': " + shape + ", }\n"
+		buf := bytes.NewBufferString(npyMagic + "\x01\x00")
+		buf.Write([]byte{byte(len(header)), 0})
+		buf.WriteString(header + "\x00\x00\x80\x3f")
+		if _, _, err := ReadNpy(buf); err == nil {
+			t.Errorf("Shape %s should be rejected", shape)
+		}
+	}
+}
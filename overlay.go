@@ -0,0 +1,91 @@
+package main
+
+// Overlays are images drawn by the CPU and composited on top of the grid
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	glad "github.com/akiross/go-glad"
+	"github.com/go-gl/gl/v4.5-core/gl"
+)
+
+type Overlay struct {
+	Img  *image.RGBA
+	txr  glad.Texture
+	auto *glad.AutoConfig
+}
+
+// Creates an overlay of w*h pixels, to be placed with Place
+func NewOverlay(w, h int) *Overlay {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	txr := glad.NewTexture()
+	txr.Storage2D(w, h)
+	txr.Bind()
+	txr.Image2D(img)
+	txr.SetFilters(gl.NEAREST, gl.NEAREST)
+
+	auto := glad.AutoBuild(&glad.Config{
+		Shaders: []glad.Shader{
+			glad.NewShader(passThruVS, gl.VERTEX_SHADER),
+			glad.NewShader(passThruFS, gl.FRAGMENT_SHADER),
+		},
+		Attributes: []glad.Attr{{0, "pos", 2}, {0, "uv", 2}},
+		Data:       [][]float32{quad(-1, -1, 1, 1)},
+		DataUsages: []uint32{gl.DYNAMIC_DRAW},
+		Primitives: gl.TRIANGLE_STRIP,
+		Textures:   []glad.Texture{txr},
+	})
+	return &Overlay{img, txr, auto}
+}
+
+// Vertices of a quad in NDC, with UV flipped so that the first image row is on top
+func quad(x0, y0, x1, y1 float32) []float32 {
+	return []float32{
+		x0, y0, 0.0, 1.0,
+		x0, y1, 0.0, 0.0,
+		x1, y0, 1.0, 1.0,
+		x1, y1, 1.0, 0.0,
+	}
+}
+
+// Converts a rectangle of w*h pixels at (x,y) from the top-left corner of a
+// framebuffer of size fbW*fbH to NDC
+func pixelRect(x, y, w, h, fbW, fbH int) (x0, y0, x1, y1 float32) {
+	x0 = -1.0 + 2.0*float32(x)/float32(fbW)
+	x1 = -1.0 + 2.0*float32(x+w)/float32(fbW)
+	y1 = 1.0 - 2.0*float32(y)/float32(fbH)
+	y0 = 1.0 - 2.0*float32(y+h)/float32(fbH)
+	return
+}
+
+// Places the overlay at pixel position (x,y) from the top-left corner of
+// a framebuffer of size fbW*fbH, keeping the overlay pixels unscaled
+func (ov *Overlay) Place(x, y, fbW, fbH int) {
+	b := ov.Img.Bounds()
+	ov.PlaceRect(x, y, b.Dx(), b.Dy(), fbW, fbH)
+}
+
+// Places the overlay stretching it on a rectangle of w*h pixels
+func (ov *Overlay) PlaceRect(x, y, w, h, fbW, fbH int) {
+	ov.auto.VBOs[0].BufferSubData32(quad(pixelRect(x, y, w, h, fbW, fbH)), 0)
+}
+
+// Fills the whole image with color c
+func (ov *Overlay) Clear(c color.RGBA) {
+	draw.Draw(ov.Img, ov.Img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+// Sends the image to the GPU, to be called after drawing on Img
+func (ov *Overlay) Upload() {
+	ov.txr.Bind()
+	ov.txr.Image2D(ov.Img)
+}
+
+func (ov *Overlay) Draw() {
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	ov.auto.AutoDraw()
+	gl.Disable(gl.BLEND)
+}
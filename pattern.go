@@ -0,0 +1,174 @@
+package main
+
+// Spatial patterns presented to a region of the grid, like frames of a
+// small image sequence
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A sequence of frames of W*H values, rows from top to bottom
+type Pattern struct {
+	W, H   int
+	Frames [][]float32
+}
+
+func (p *Pattern) At(f, x, y int) float32 {
+	return p.Frames[f][y*p.W+x]
+}
+
+// Shape of the region covered by a pattern
+type Shape int
+
+const (
+	RectShape Shape = iota // Pattern pixels on cells, anchored at the bottom-left cell
+	HexShape               // Hexagon inscribed in the pattern, anchored at the center
+)
+
+func ParseShape(s string) (Shape, error) {
+	switch s {
+	case "rect":
+		return RectShape, nil
+	case "hex":
+		return HexShape, nil
+	}
+	return RectShape, fmt.Errorf("unknown shape %q", s)
+}
+
+// A vertical (or horizontal) bar of given width sweeping a w*h pattern,
+// one position per frame
+func MovingBar(w, h, width int, horizontal bool) *Pattern {
+	p := &Pattern{W: w, H: h}
+	n := w
+	if horizontal {
+		n = h
+	}
+	for f := 0; f < n; f++ {
+		frame := make([]float32, w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				pos := x
+				if horizontal {
+					pos = y
+				}
+				if pos >= f && pos < f+width {
+					frame[y*w+x] = 1
+				}
+			}
+		}
+		p.Frames = append(p.Frames, frame)
+	}
+	return p
+}
+
+// Loads images as frames of a pattern, using their luminance in [0,1]
+func LoadPatternImages(paths []string) (*Pattern, error) {
+	p := &Pattern{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		b := img.Bounds()
+		if len(p.Frames) == 0 {
+			p.W, p.H = b.Dx(), b.Dy()
+		} else if b.Dx() != p.W || b.Dy() != p.H {
+			return nil, fmt.Errorf("%s: size %dx%d differs from first frame %dx%d", path, b.Dx(), b.Dy(), p.W, p.H)
+		}
+		frame := make([]float32, p.W*p.H)
+		for y := 0; y < p.H; y++ {
+			for x := 0; x < p.W; x++ {
+				r, g, b, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				frame[y*p.W+x] = (0.299*float32(r) + 0.587*float32(g) + 0.114*float32(b)) / 0xffff
+			}
+		}
+		p.Frames = append(p.Frames, frame)
+	}
+	if len(p.Frames) == 0 {
+		return nil, fmt.Errorf("no frames")
+	}
+	return p, nil
+}
+
+// Builds a pattern from a description: either "bar:WxH" for a vertical
+// moving bar ("hbar:WxH" for horizontal), or a glob matching image files
+// used as frames in lexical order
+func ParsePattern(spec string) (*Pattern, error) {
+	if kind := strings.SplitN(spec, ":", 2); len(kind) == 2 && (kind[0] == "bar" || kind[0] == "hbar") {
+		size := strings.SplitN(kind[1], "x", 2)
+		if len(size) != 2 {
+			return nil, fmt.Errorf("bad pattern size %q", kind[1])
+		}
+		w, err1 := strconv.Atoi(size[0])
+		h, err2 := strconv.Atoi(size[1])
+		if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("bad pattern size %q", kind[1])
+		}
+		return MovingBar(w, h, 1, kind[0] == "hbar"), nil
+	}
+	paths, err := filepath.Glob(spec)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPatternImages(paths)
+}
+
+// Binds a pattern to a region of the grid anchored in (x,y). Rows of the
+// pattern are shifted by half a cell every other row, so that the region
+// looks rectangular on screen
+func (hg *HexGrid) BindRegion(x, y int, p *Pattern, shape Shape, opts ...BindOption) {
+	var cells []boundCell
+	switch shape {
+	case RectShape:
+		for j := 0; j < p.H; j++ {
+			// Pattern rows go top to bottom, grid rows bottom to top
+			row := p.H - 1 - j
+			shift := (j + (y & 1)) / 2
+			for i := 0; i < p.W; i++ {
+				cx, cy := hg.wrap(x+i-shift, y+j)
+				cells = append(cells, boundCell{cx, cy, row*p.W + i})
+			}
+		}
+	case HexShape:
+		// Each cell takes the pattern pixel under its center
+		cx0, cy0 := float64(p.W-1)/2, float64(p.H-1)/2
+		r := (p.W - 1) / 2
+		if (p.H-1)/2 < r {
+			r = (p.H - 1) / 2
+		}
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				if hexDistance(dx, dy) > r {
+					continue
+				}
+				px := int(math.Floor(cx0 + float64(dx) + 0.5*float64(dy) + 0.5))
+				py := int(math.Floor(cy0 - float64(dy) + 0.5))
+				if px < 0 || px >= p.W || py < 0 || py >= p.H {
+					continue
+				}
+				cx, cy := hg.wrap(x+dx, y+dy)
+				cells = append(cells, boundCell{cx, cy, py*p.W + px})
+			}
+		}
+	}
+
+	data := make([]float32, 0, len(p.Frames)*p.W*p.H)
+	for _, f := range p.Frames {
+		data = append(data, f...)
+	}
+	x, y = hg.wrap(x, y)
+	hg.bindCells(x, y, cells, p.W*p.H, data, opts)
+}
@@ -0,0 +1,192 @@
+package main
+
+// Time series plots of the simulation, drawn on an overlay
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Colors assigned to series, in order
+var palette = []color.RGBA{
+	{230, 25, 75, 255},
+	{60, 180, 75, 255},
+	{0, 130, 200, 255},
+	{245, 130, 48, 255},
+	{145, 30, 180, 255},
+	{70, 240, 240, 255},
+	{240, 50, 230, 255},
+	{210, 245, 60, 255},
+}
+
+// A named sequence of values, keeping only the most recent ones
+type Series struct {
+	Name  string
+	Color color.RGBA
+	data  []float32 // Ring buffer
+	head  int       // Position of the next value
+	n     int       // Number of values stored
+}
+
+func NewSeries(name string, c color.RGBA, capacity int) *Series {
+	return &Series{Name: name, Color: c, data: make([]float32, capacity)}
+}
+
+func (s *Series) Add(v float32) {
+	s.data[s.head] = v
+	s.head = (s.head + 1) % len(s.data)
+	if s.n < len(s.data) {
+		s.n++
+	}
+}
+
+func (s *Series) Len() int {
+	return s.n
+}
+
+// Returns the i-th value, from the oldest one
+func (s *Series) At(i int) float32 {
+	return s.data[(s.head-s.n+i+len(s.data))%len(s.data)]
+}
+
+// A box in the plot, where several series share the same axes
+type Panel struct {
+	Title  string
+	Series []*Series
+}
+
+// Adds a new series to the panel, picking the next color in the palette
+func (p *Panel) Add(name string, capacity int) *Series {
+	s := NewSeries(name, palette[len(p.Series)%len(palette)], capacity)
+	p.Series = append(p.Series, s)
+	return s
+}
+
+// Removes the series with given name, if present
+func (p *Panel) Remove(name string) {
+	for i, s := range p.Series {
+		if s.Name == name {
+			p.Series = append(p.Series[:i], p.Series[i+1:]...)
+			return
+		}
+	}
+}
+
+type Plot struct {
+	*Overlay
+	Panels  []*Panel
+	Visible bool
+}
+
+// Creates a plot of w*h pixels with stacked panels having the given titles
+func NewPlot(w, h int, titles ...string) *Plot {
+	p := &Plot{Overlay: NewOverlay(w, h), Visible: true}
+	for _, t := range titles {
+		p.Panels = append(p.Panels, &Panel{Title: t})
+	}
+	return p
+}
+
+// Draws all the panels and sends the result to the GPU
+func (p *Plot) Render() {
+	p.Clear(color.RGBA{40, 40, 40, 255})
+	b := p.Img.Bounds()
+	ph := b.Dy() / len(p.Panels)
+	for i, pan := range p.Panels {
+		p.renderPanel(pan, image.Rect(0, i*ph, b.Dx(), (i+1)*ph).Inset(6))
+	}
+	p.Upload()
+}
+
+func (p *Plot) renderPanel(pan *Panel, r image.Rectangle) {
+	face := basicfont.Face7x13
+	white := color.RGBA{255, 255, 255, 255}
+	grey := color.RGBA{120, 120, 120, 255}
+
+	// Title and legend
+	d := font.Drawer{Dst: p.Img, Src: image.NewUniform(white), Face: face}
+	d.Dot = fixed.P(r.Min.X, r.Min.Y+face.Ascent)
+	d.DrawString(pan.Title)
+	for _, s := range pan.Series {
+		d.Src = image.NewUniform(s.Color)
+		d.DrawString("  " + s.Name)
+	}
+	area := image.Rect(r.Min.X, r.Min.Y+face.Height+2, r.Max.X, r.Max.Y)
+
+	// Vertical range is shared by all the series
+	lo, hi := float32(0), float32(1)
+	for _, s := range pan.Series {
+		for i := 0; i < s.Len(); i++ {
+			if v := s.At(i); v < lo {
+				lo = v
+			} else if v > hi {
+				hi = v
+			}
+		}
+	}
+	if hi-lo < 1e-6 {
+		hi = lo + 1
+	}
+
+	// Frame and range labels
+	drawLine(p.Img, area.Min.X, area.Min.Y, area.Max.X-1, area.Min.Y, grey)
+	drawLine(p.Img, area.Min.X, area.Max.Y-1, area.Max.X-1, area.Max.Y-1, grey)
+	drawLine(p.Img, area.Min.X, area.Min.Y, area.Min.X, area.Max.Y-1, grey)
+	drawLine(p.Img, area.Max.X-1, area.Min.Y, area.Max.X-1, area.Max.Y-1, grey)
+	d.Src = image.NewUniform(grey)
+	d.Dot = fixed.P(area.Min.X+3, area.Min.Y+face.Ascent+2)
+	d.DrawString(fmt.Sprintf("%.3g", hi))
+	d.Dot = fixed.P(area.Min.X+3, area.Max.Y-4)
+	d.DrawString(fmt.Sprintf("%.3g", lo))
+
+	// Series are drawn right-aligned, one pixel per value
+	for _, s := range pan.Series {
+		n := s.Len()
+		if n > area.Dx() {
+			n = area.Dx()
+		}
+		px, py := 0, 0
+		for i := 0; i < n; i++ {
+			v := s.At(s.Len() - n + i)
+			x := area.Max.X - n + i
+			y := area.Max.Y - 1 - int((v-lo)/(hi-lo)*float32(area.Dy()-1))
+			if i > 0 {
+				drawLine(p.Img, px, py, x, y, s.Color)
+			}
+			px, py = x, y
+		}
+	}
+}
+
+// Bresenham line from (x0,y0) to (x1,y1)
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
@@ -1,4 +1,12 @@
 #version 410
 in float gColor; // Color from geometry shader
+in float gHighlight; // Highlight from geometry shader
+in float gKind; // Cell type from geometry shader
 out vec4 oColor; // Color of fragment
-void main() { oColor = vec4(1.0 - gColor, 1.0 - gColor, 1.0 - gColor, 1.0); }
+void main() {
+	vec4 c = vec4(1.0 - gColor, 1.0 - gColor, 1.0 - gColor, 1.0);
+	// Inhibitory cells are tinted in red
+	c = mix(c, vec4(0.9, 0.1, 0.1, 1.0), 0.4 * gKind);
+	// Highlighted cells are tinted in yellow
+	oColor = mix(c, vec4(1.0, 0.8, 0.0, 1.0), 0.5 * gHighlight);
+}
@@ -1,14 +1,27 @@
 #version 450 core
 
-in vec3 vert; // Input center position for this hexagon
+in vec4 vert; // Input center position for this hexagon (z is hex side times view zoom, w is inverse aspect ratio)
 in float color; // Input color for this hexagon
 in vec3 weights; // Input weights for this hexagon
+in vec3 weightsB; // Weights of the other 3 sides, only in directed mode
+in float highlight; // 1 if this hexagon is highlighted
+in float kind; // 1 if this is an inhibitory cell
 
 out float vColor; // Color to be forwarded to geometry shader
 out vec3 vWeights;
+out vec3 vWeightsB;
+out float vZoom; // Zoom to be applied to hexagon size
+out float vInvAspect;
+out float vHighlight;
+out float vKind;
 
 void main() {
-	gl_Position = vec4(vert, 1);
+	gl_Position = vec4(vert.xy, 0, 1);
+	vZoom = vert.z;
+	vInvAspect = vert.w;
 	vColor = color;
 	vWeights = weights;
+	vWeightsB = weightsB;
+	vHighlight = highlight;
+	vKind = kind;
 }
@@ -0,0 +1,131 @@
+package main
+
+// Networks of several grids stacked in layers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Connection between layers of a stack: every cell of To receives the mean
+// value of the cells of From within distance Field of the corresponding cell,
+// times Weight
+type Link struct {
+	From   int     `json:"from"`
+	To     int     `json:"to"`
+	Weight float32 `json:"weight"`
+	Field  int     `json:"field"`
+}
+
+// Layers are updated in order, so links from lower layers carry the values
+// of the current step (feedforward) and the others the ones of the previous
+// step (feedback)
+type Stack struct {
+	Layers []*HexGrid
+	Links  []Link
+}
+
+func NewStack(layers ...*HexGrid) *Stack {
+	return &Stack{Layers: layers}
+}
+
+func (s *Stack) Connect(ln Link) error {
+	if ln.From < 0 || ln.From >= len(s.Layers) || ln.To < 0 || ln.To >= len(s.Layers) || ln.From == ln.To {
+		return fmt.Errorf("invalid link from layer %d to %d", ln.From, ln.To)
+	}
+	if ln.Field < 0 {
+		return fmt.Errorf("invalid receptive field %d", ln.Field)
+	}
+	s.Links = append(s.Links, ln)
+	return nil
+}
+
+// Connects every layer to the next with weight ff and to the previous with
+// weight fb, links with zero weight are left out
+func (s *Stack) ConnectLayers(ff, fb float32, field int) {
+	for i := 0; i+1 < len(s.Layers); i++ {
+		if ff != 0 {
+			s.Connect(Link{i, i + 1, ff, field})
+		}
+		if fb != 0 {
+			s.Connect(Link{i + 1, i, fb, field})
+		}
+	}
+}
+
+// Cell of from at the same relative position of (x,y) in to
+func corresponding(from, to *HexGrid, x, y int) (int, int) {
+	return x * from.W / to.W, y * from.H / to.H
+}
+
+// Adds the input carried by a link to its target layer
+func (s *Stack) feed(ln Link) {
+	from, to := s.Layers[ln.From], s.Layers[ln.To]
+	for y := 0; y < to.H; y++ {
+		for x := 0; x < to.W; x++ {
+			cx, cy := corresponding(from, to, x, y)
+			var sum float32
+			n := 0
+			for dy := -ln.Field; dy <= ln.Field; dy++ {
+				for dx := -ln.Field; dx <= ln.Field; dx++ {
+					if hexDistance(dx, dy) <= ln.Field {
+						sum += from.Get(cx+dx, cy+dy)
+						n++
+					}
+				}
+			}
+			to.Input[y*to.W+x] += ln.Weight * sum / float32(n)
+		}
+	}
+}
+
+func (s *Stack) Update() {
+	for i, l := range s.Layers {
+		l.Input = nil
+		for _, ln := range s.Links {
+			if ln.To != i {
+				continue
+			}
+			if l.Input == nil {
+				l.Input = make([]float32, l.W*l.H)
+			}
+			s.feed(ln)
+		}
+		l.Update()
+	}
+}
+
+// Returns a deep copy of all the layers
+func (s *Stack) Clone() *Stack {
+	c := &Stack{Links: s.Links}
+	for _, l := range s.Layers {
+		c.Layers = append(c.Layers, l.Clone())
+	}
+	return c
+}
+
+// Brings the layers back to the state of a clone, keeping the same grids
+func (s *Stack) Restore(c *Stack) {
+	for i, l := range s.Layers {
+		l.Restore(c.Layers[i])
+	}
+}
+
+// Parses sizes of layers as comma separated ROWSxCOLS
+func ParseLayers(spec string) ([][2]int, error) {
+	var sizes [][2]int
+	for _, p := range strings.Split(spec, ",") {
+		rc := strings.Split(strings.TrimSpace(p), "x")
+		if len(rc) != 2 {
+			return nil, fmt.Errorf("invalid layer size %q, expected ROWSxCOLS", p)
+		}
+		r, err1 := strconv.Atoi(rc[0])
+		c, err2 := strconv.Atoi(rc[1])
+		if err1 != nil || err2 != nil || r < 1 || c < 1 {
+			return nil, fmt.Errorf("invalid layer size %q", p)
+		}
+		sizes = append(sizes, [2]int{r, c})
+	}
+	return sizes, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestStack(t *testing.T) {
+	low, high := NewGrid(8, 8), NewGrid(4, 4)
+	quietGrid(low, 0.5, 0.5)
+	quietGrid(high, 0.5, 0.5)
+	s := NewStack(low, high)
+	s.ConnectLayers(1, 0, 1)
+	if len(s.Links) != 1 || s.Connect(Link{0, 0, 1, 0}) == nil || s.Connect(Link{0, 2, 1, 0}) == nil {
+		t.Fatal("Wrong links", s.Links)
+	}
+
+	// Region of the lower layer under cell (1,1) of the upper one fires
+	for y := 1; y <= 3; y++ {
+		for x := 1; x <= 3; x++ {
+			low.Set(x, y, 1)
+		}
+	}
+	if x, y := corresponding(low, high, 1, 1); x != 2 || y != 2 {
+		t.Error("Wrong corresponding cell", x, y)
+	}
+	snap := s.Clone()
+	s.Update()
+	if high.Get(1, 1) != 1 || high.Get(3, 3) != 0 {
+		t.Error("Feedforward input should activate the corresponding cell only")
+	}
+	s.Restore(snap)
+	if high.Get(1, 1) != 0 || s.Layers[1] != high {
+		t.Error("Wrong restore")
+	}
+
+	if sizes, err := ParseLayers("10x20, 5x5"); err != nil || len(sizes) != 2 || sizes[0] != [2]int{10, 20} {
+		t.Error("Wrong layers", sizes, err)
+	}
+	if _, err := ParseLayers("10"); err == nil {
+		t.Error("Invalid size should fail")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+// Parameter sweeps: many headless runs over combinations of parameters
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A parameter of the update rule and the values it takes
+type SweepRange struct {
+	Name   string
+	Values []float32
+}
+
+// Parses name=from:to:step or name=v1,v2,...
+// Names are the ones used in JSON, e.g. decay_factor
+func ParseRange(spec string) (SweepRange, error) {
+	r := SweepRange{}
+	eq := strings.Index(spec, "=")
+	if eq < 0 {
+		return r, fmt.Errorf("invalid range %q, expected name=values", spec)
+	}
+	r.Name = spec[:eq]
+	if err := setParam(&Params{}, r.Name, 0); err != nil {
+		return r, err
+	}
+	parse := func(s string) (float32, error) {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 32)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", r.Name, err)
+		}
+		return float32(v), nil
+	}
+
+	vals := spec[eq+1:]
+	if parts := strings.Split(vals, ":"); len(parts) == 3 {
+		var b [3]float32
+		for i, p := range parts {
+			v, err := parse(p)
+			if err != nil {
+				return r, err
+			}
+			b[i] = v
+		}
+		if b[2] <= 0 || b[1] < b[0] {
+			return r, fmt.Errorf("%s: invalid range %s", r.Name, vals)
+		}
+		// Steps are counted to avoid accumulating rounding errors
+		n := int((b[1]-b[0])/b[2] + 1e-4)
+		for i := 0; i <= n; i++ {
+			r.Values = append(r.Values, b[0]+float32(i)*b[2])
+		}
+		return r, nil
+	}
+	for _, p := range strings.Split(vals, ",") {
+		v, err := parse(p)
+		if err != nil {
+			return r, err
+		}
+		r.Values = append(r.Values, v)
+	}
+	return r, nil
+}
+
+// Sets the parameter with given JSON name
+func setParam(p *Params, name string, v float32) error {
+	pv := reflect.ValueOf(p).Elem()
+	for i := 0; i < pv.NumField(); i++ {
+		if pv.Type().Field(i).Tag.Get("json") == name {
+			if pv.Field(i).Kind() != reflect.Float32 {
+				return fmt.Errorf("parameter %q is not numeric", name)
+			}
+			pv.Field(i).SetFloat(float64(v))
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown parameter %q", name)
+}
+
+// Every combination of values, the last range changing faster
+func combinations(ranges []SweepRange) [][]float32 {
+	combs := [][]float32{{}}
+	for _, r := range ranges {
+		var next [][]float32
+		for _, c := range combs {
+			for _, v := range r.Values {
+				next = append(next, append(append([]float32(nil), c...), v))
+			}
+		}
+		combs = next
+	}
+	return combs
+}
+
+// Summary of a run
+type RunSummary struct {
+	MeanFiring    float32 // Fraction of firing cells, averaged over steps
+	FinalFiring   float32
+	MeanWeight    float32 // At the end of the run
+	MeanThreshold float32 // At the end of the run
+	MoranI        float32 // At the end of the run
+	Entropy       float32 // Of firing over the run
+}
+
+// Updates the grid for a number of steps
+func RunHeadless(g *HexGrid, steps int) RunSummary {
+	var s RunSummary
+	m := NewMetrics()
+	for i := 0; i < steps; i++ {
+		g.Update()
+		m.Observe(i+1, g)
+		s.MeanFiring += g.Stats().Firing
+	}
+	st := g.Stats()
+	s.MeanFiring /= float32(steps)
+	s.FinalFiring = st.Firing
+	s.MeanWeight = st.MeanWeight
+	s.MeanThreshold = st.MeanThreshold
+	s.MoranI = float32(MoranI(g))
+	s.Entropy = float32(m.Entropy())
+	return s
+}
+
+type Sweep struct {
+	Base   Params
+	Ranges []SweepRange
+	Seeds  []int64
+	Steps  int
+	Jobs   int                                 // Runs at the same time
+	Grid   func(p Params, seed int64) *HexGrid // Creates the grid of a run
+}
+
+// Results for each combination of values, averaged over seeds
+type SweepResult struct {
+	Values  []float32
+	Summary RunSummary
+}
+
+// Performs every run, each in its own goroutine. Runs are averaged in
+// order of seed, so results do not depend on the order they end in
+func (sw *Sweep) Run() []SweepResult {
+	combs := combinations(sw.Ranges)
+	results := make([]SweepResult, len(combs))
+	runs := make([][]RunSummary, len(combs))
+	jobs := make(chan struct{}, sw.Jobs)
+	var wg sync.WaitGroup
+	for i, c := range combs {
+		results[i].Values = c
+		runs[i] = make([]RunSummary, len(sw.Seeds))
+		p := sw.Base
+		for j, r := range sw.Ranges {
+			setParam(&p, r.Name, c[j])
+		}
+		for k, seed := range sw.Seeds {
+			wg.Add(1)
+			go func(s *RunSummary, p Params, seed int64) {
+				defer wg.Done()
+				jobs <- struct{}{}
+				*s = RunHeadless(sw.Grid(p, seed), sw.Steps)
+				<-jobs
+			}(&runs[i][k], p, seed)
+		}
+	}
+	wg.Wait()
+
+	n := float32(len(sw.Seeds))
+	for i := range results {
+		res := &results[i].Summary
+		for _, s := range runs[i] {
+			res.MeanFiring += s.MeanFiring / n
+			res.FinalFiring += s.FinalFiring / n
+			res.MeanWeight += s.MeanWeight / n
+			res.MeanThreshold += s.MeanThreshold / n
+			res.MoranI += s.MoranI / n
+			res.Entropy += s.Entropy / n
+		}
+	}
+	return results
+}
+
+// Writes results as tab separated values, one row per combination
+func (sw *Sweep) Write(w io.Writer, results []SweepResult) error {
+	var cols []string
+	for _, r := range sw.Ranges {
+		cols = append(cols, r.Name)
+	}
+	cols = append(cols, "mean_firing", "final_firing", "mean_weight", "mean_threshold", "moran_i", "entropy")
+	if _, err := fmt.Fprintln(w, strings.Join(cols, "\t")); err != nil {
+		return err
+	}
+	for _, res := range results {
+		row := make([]string, 0, len(cols))
+		for _, v := range res.Values {
+			row = append(row, strconv.FormatFloat(float64(v), 'g', 6, 32))
+		}
+		s := res.Summary
+		for _, v := range []float32{s.MeanFiring, s.FinalFiring, s.MeanWeight, s.MeanThreshold, s.MoranI, s.Entropy} {
+			row = append(row, strconv.FormatFloat(float64(v), 'g', 6, 32))
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
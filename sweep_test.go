@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("decay_factor=0.5:0.9:0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Values) != 5 || r.Values[4] < 0.89 {
+		t.Error("Wrong values", r.Values)
+	}
+	if r, err = ParseRange("weight_increase_factor=1.01,1.05"); err != nil || len(r.Values) != 2 {
+		t.Error("Wrong list", r.Values, err)
+	}
+	if _, err = ParseRange("speed=1,2"); err == nil {
+		t.Error("Unknown parameter should fail")
+	}
+	if n := len(combinations([]SweepRange{{"a", []float32{1, 2}}, {"b", []float32{3, 4, 5}}})); n != 6 {
+		t.Error("Wrong number of combinations", n)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	r, _ := ParseRange("decay_factor=0.5,0.9")
+	sw := &Sweep{
+		Base:   DefaultParams(),
+		Ranges: []SweepRange{r},
+		Seeds:  []int64{1, 2},
+		Steps:  5,
+		Jobs:   2,
+		Grid: func(p Params, seed int64) *HexGrid {
+			return NewGrid(8, 8, WithParams(p), Seed(seed))
+		},
+	}
+	res := sw.Run()
+	var buf bytes.Buffer
+	sw.Write(&buf, res)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "0.9\t") {
+		t.Error("Wrong table", lines)
+	}
+
+	// Same results whatever the order runs end in
+	sw.Seeds = []int64{1, 2, 3, 4, 5, 6}
+	sw.Jobs = 6
+	first := sw.Run()
+	for i := 0; i < 5; i++ {
+		for j, r := range sw.Run() {
+			if r.Summary != first[j].Summary {
+				t.Fatal("Results should not change", r.Summary, first[j].Summary)
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+// Trace files record the evolution of a grid, to replay it later without
+// computing it again. The file starts with a header describing the run,
+// followed by chunks of steps compressed with flate. Each chunk is:
+//   uint32 number of steps in the chunk
+//   uint32 length of compressed data
+//   compressed frames, each one made of
+//     uint32 step, Data, and WData and Thres if recorded (float32)
+// All the numbers are little endian
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	traceMagic   = "GEXTRACE"
+	traceVersion = 1
+
+	// Limits on sizes read from files, checked before allocating
+	maxTraceHeader = 1 << 26
+	maxTraceCells  = 1 << 26
+)
+
+type TraceHeader struct {
+	Version int    `json:"version"`
+	W       int    `json:"w"`
+	H       int    `json:"h"`
+	Seed    int64  `json:"seed"`
+	Params  Params `json:"params"`
+	Weights bool   `json:"weights"`    // WData is recorded
+	Thres   bool   `json:"thresholds"` // Thres is recorded
+	WLen    int    `json:"weights_len"`
+
+	Directed bool `json:"directed,omitempty"`
+	Radius   int  `json:"radius,omitempty"`
+
+	Inhibitory []int `json:"inhibitory,omitempty"` // Indices of inhibitory cells
+}
+
+// Sets the types of cells of g as they were when recording
+func (h *TraceHeader) SetTypes(g *HexGrid) error {
+	for _, i := range h.Inhibitory {
+		if i < 0 || i >= len(g.Types) {
+			return fmt.Errorf("inhibitory cell %d out of the grid", i)
+		}
+	}
+	for i := range g.Types {
+		g.Types[i] = Excitatory
+	}
+	for _, i := range h.Inhibitory {
+		g.Types[i] = Inhibitory
+	}
+	return nil
+}
+
+// State of the grid at a given step
+type TraceFrame struct {
+	Step               int
+	Data, WData, Thres []float32
+}
+
+type TraceWriter struct {
+	Header TraceHeader
+	w      *bufio.Writer
+	chunk  int          // Steps in a chunk
+	n      int          // Steps in the current chunk
+	buf    bytes.Buffer // Uncompressed current chunk
+}
+
+// Writes the header of a trace for grid g, recording weights and
+// thresholds if requested. Every chunk holds up to chunk steps
+func NewTraceWriter(w io.Writer, g *HexGrid, weights, thres bool, chunk int) (*TraceWriter, error) {
+	tw := &TraceWriter{
+		Header: TraceHeader{traceVersion, g.W, g.H, g.Seed, g.P, weights, thres, len(g.WData), g.Directed, g.Radius, nil},
+		w:      bufio.NewWriter(w),
+		chunk:  chunk,
+	}
+	for i, t := range g.Types {
+		if t == Inhibitory {
+			tw.Header.Inhibitory = append(tw.Header.Inhibitory, i)
+		}
+	}
+	hdr, err := json.Marshal(tw.Header)
+	if err != nil {
+		return nil, err
+	}
+	tw.w.WriteString(traceMagic)
+	binary.Write(tw.w, binary.LittleEndian, uint32(len(hdr)))
+	if _, err := tw.w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return tw, nil
+}
+
+// Records the state of the grid at given step
+func (tw *TraceWriter) Write(step int, g *HexGrid) error {
+	binary.Write(&tw.buf, binary.LittleEndian, uint32(step))
+	writeFloats(&tw.buf, g.Data)
+	if tw.Header.Weights {
+		writeFloats(&tw.buf, g.WData)
+	}
+	if tw.Header.Thres {
+		writeFloats(&tw.buf, g.Thres)
+	}
+	if tw.n++; tw.n >= tw.chunk {
+		return tw.flush()
+	}
+	return nil
+}
+
+func (tw *TraceWriter) flush() error {
+	if tw.n == 0 {
+		return nil
+	}
+	var comp bytes.Buffer
+	fw, _ := flate.NewWriter(&comp, flate.DefaultCompression)
+	fw.Write(tw.buf.Bytes())
+	fw.Close()
+	binary.Write(tw.w, binary.LittleEndian, [2]uint32{uint32(tw.n), uint32(comp.Len())})
+	if _, err := tw.w.Write(comp.Bytes()); err != nil {
+		return err
+	}
+	tw.n = 0
+	tw.buf.Reset()
+	return tw.w.Flush()
+}
+
+// Writes the pending steps, the underlying writer is not closed
+func (tw *TraceWriter) Close() error {
+	return tw.flush()
+}
+
+type TraceReader struct {
+	Header TraceHeader
+	r      *bufio.Reader
+	chunk  *bytes.Reader // Uncompressed current chunk
+	n      int           // Steps left in the current chunk
+}
+
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	tr := &TraceReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(tr.r, magic); err != nil || string(magic) != traceMagic {
+		return nil, fmt.Errorf("not a trace file")
+	}
+	var hlen uint32
+	if err := binary.Read(tr.r, binary.LittleEndian, &hlen); err != nil {
+		return nil, err
+	}
+	if hlen > maxTraceHeader {
+		return nil, fmt.Errorf("bad trace header: %d bytes", hlen)
+	}
+	hdr := make([]byte, hlen)
+	if _, err := io.ReadFull(tr.r, hdr); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(hdr, &tr.Header); err != nil {
+		return nil, fmt.Errorf("bad trace header: %v", err)
+	}
+	if tr.Header.Version != traceVersion {
+		return nil, fmt.Errorf("unsupported trace version %d", tr.Header.Version)
+	}
+	if err := tr.Header.check(); err != nil {
+		return nil, fmt.Errorf("bad trace header: %v", err)
+	}
+	return tr, nil
+}
+
+// Checks that sizes are consistent, so that frames can be read safely
+func (h *TraceHeader) check() error {
+	if h.W <= 0 || h.H <= 0 || h.W > maxTraceCells/h.H {
+		return fmt.Errorf("invalid size %dx%d", h.W, h.H)
+	}
+	r := h.Radius
+	if r == 0 {
+		r = 1 // Traces recorded before radius was configurable
+	}
+	if r < 0 || r > h.W+h.H {
+		return fmt.Errorf("invalid radius %d", h.Radius)
+	}
+	stride := 3 * r * (r + 1)
+	if !h.Directed {
+		stride /= 2
+	}
+	if stride > maxTraceCells/(h.W*h.H) || h.WLen != h.W*h.H*stride {
+		return fmt.Errorf("%d weights for a %dx%d grid", h.WLen, h.W, h.H)
+	}
+	return nil
+}
+
+// Reads the next frame, returns io.EOF at the end of the trace
+func (tr *TraceReader) Next() (*TraceFrame, error) {
+	if tr.n == 0 {
+		var hdr [2]uint32
+		if err := binary.Read(tr.r, binary.LittleEndian, &hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF // Truncated trace, stop at the last complete chunk
+			}
+			return nil, err
+		}
+		data, err := io.ReadAll(flate.NewReader(io.LimitReader(tr.r, int64(hdr[1]))))
+		if err != nil {
+			return nil, fmt.Errorf("corrupted chunk: %v", err)
+		}
+		tr.chunk, tr.n = bytes.NewReader(data), int(hdr[0])
+	}
+
+	h := &tr.Header
+	f := &TraceFrame{}
+	var step uint32
+	if err := binary.Read(tr.chunk, binary.LittleEndian, &step); err != nil {
+		return nil, fmt.Errorf("corrupted chunk: %v", err)
+	}
+	f.Step = int(step)
+	var err error
+	if f.Data, err = readFloats(tr.chunk, h.W*h.H); err != nil {
+		return nil, err
+	}
+	if h.Weights {
+		if f.WData, err = readFloats(tr.chunk, h.WLen); err != nil {
+			return nil, err
+		}
+	}
+	if h.Thres {
+		if f.Thres, err = readFloats(tr.chunk, h.W*h.H); err != nil {
+			return nil, err
+		}
+	}
+	tr.n--
+	return f, nil
+}
+
+func writeFloats(w io.Writer, v []float32) {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	w.Write(b)
+}
+
+func readFloats(r io.Reader, n int) ([]float32, error) {
+	b := make([]byte, 4*n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("corrupted chunk: %v", err)
+	}
+	v := make([]float32, n)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	g := NewGrid(5, 4, Seed(42))
+	var buf bytes.Buffer
+	tw, err := NewTraceWriter(&buf, g, true, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []*HexGrid
+	for s := 0; s < 7; s++ {
+		tw.Write(s, g)
+		states = append(states, g.Clone())
+		g.Update()
+	}
+	tw.Close()
+
+	tr, err := NewTraceReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Header.W != 5 || tr.Header.H != 4 || tr.Header.Seed != 42 || tr.Header.Thres {
+		t.Error("Wrong header", tr.Header)
+	}
+	for s := 0; ; s++ {
+		f, err := tr.Next()
+		if err == io.EOF {
+			if s != 7 {
+				t.Error("Wrong number of frames", s)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if f.Step != s || f.Thres != nil {
+			t.Error("Wrong frame", s, f.Step)
+		}
+		for i := range f.Data {
+			if f.Data[i] != states[s].Data[i] {
+				t.Fatal("Wrong data", s, i)
+			}
+		}
+		for i := range f.WData {
+			if f.WData[i] != states[s].WData[i] {
+				t.Fatal("Wrong weights", s, i)
+			}
+		}
+	}
+}
+
+func TestTraceTypes(t *testing.T) {
+	g := NewGrid(5, 4)
+	h := &TraceHeader{Inhibitory: []int{3, 19}}
+	if err := h.SetTypes(g); err != nil || g.Types[3] != Inhibitory || g.Types[19] != Inhibitory || g.Types[4] != Excitatory {
+		t.Error("Wrong types", g.Types, err)
+	}
+	for _, i := range []int{-1, 20} {
+		h.Inhibitory = []int{i}
+		if err := h.SetTypes(g); err == nil {
+			t.Error("Cell out of the grid should be rejected", i)
+		}
+	}
+}
+
+func TestTraceBadHeader(t *testing.T) {
+	g := NewGrid(5, 4, Directed(), Radius(2))
+	var buf bytes.Buffer
+	tw, _ := NewTraceWriter(&buf, g, true, false, 3)
+	tw.Write(0, g)
+	tw.Close()
+	if _, err := NewTraceReader(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range []TraceHeader{
+		{Version: traceVersion, W: -5, H: 4},
+		{Version: traceVersion, W: 5, H: 4, WLen: 5 * 4 * 3, Directed: true},
+		{Version: traceVersion, W: 5, H: 4, WLen: 5 * 4 * 3, Radius: -1},
+		{Version: traceVersion, W: 1 << 20, H: 1 << 20},
+	} {
+		if err := h.check(); err == nil {
+			t.Error("Header should be rejected", h)
+		}
+	}
+	if h := (TraceHeader{Version: traceVersion, W: 5, H: 4, WLen: 5 * 4 * 3}); h.check() != nil {
+		t.Error("Traces without radius should use 1")
+	}
+
+	bad := append([]byte(traceMagic), 0xff, 0xff, 0xff, 0xff)
+	if _, err := NewTraceReader(bytes.NewReader(bad)); err == nil {
+		t.Error("Huge header should be rejected")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/go-gl/gl/v4.5-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"strings"
 )
@@ -29,59 +30,93 @@ func CreateWindow(w, h int, title string) *glfw.Window {
 	return NewOGLWindow(w, h, title,
 		CoreProfile(true),
 		ForwardCompatible(true),
-		Resizable(false),
+		Resizable(true),
 		ContextVersion(4, 4))
 }
 
 type ViewState struct {
 	rows, cols int
+	directed   bool // Six weights per cell instead of three
 	//program           glad.Program
 	//vao, vao_txr      glad.VertexArrayObject
 	//vbo_c, vbo_w      glad.VertexBufferObject
 	//fbo_grid, fbo_env glad.FramebufferObject
 	//count             int
-	vertices []float32
+	vertices []float32 // Hex centers in world coordinates
 
-	autoGrid, autoLayout *glad.AutoConfig
-}
+	// Layout of the grid, depends on the size of the area it is drawn in
+	x, y          int // Position of the area from the top-left corner of the framebuffer
+	width, height int
+	fbW, fbH      int // Size of the framebuffer
+	aspect, side  float32
+	bx, by        float32
 
-func SetupOGL(rows, cols int, aspectRatio float32) *ViewState {
-	gl.ClearColor(0.6, 0.6, 0.6, 1.0)
-	gl.ClearColor(0.3, 0.3, 0.3, 1.0)
+	// View transform: screen = world * zoom + pan
+	zoom, panX, panY float32
 
-	// We want to fit the points in 90% of the real estate
-	var spaceW float32 = 0.9 * 2.0 * aspectRatio
-	var spaceH float32 = 0.9 * 2.0
+	// Last uploaded data, kept to rebuild buffers on resize
+	colors, weights, highlight, kinds []float32
 
-	const pho = 0.866025404 // sqrt(3/4)
+	autoGrid *glad.AutoConfig
+}
 
-	// Total width is x*(cols-1) + 0.5*x*(rows-1)
-	// Total height is pho*x*rows
-	// Where x is the distance between centers of hexagons
-	// We want to maximize x, so find the smallest x so that
-	// spaceW = x * (cols-1) + 0.5*x*(rows-1) = x * (cols - 1 + 0.5 * (rows - 1))
-	// spaceH = pho * x * rows
-	xw := spaceW / (float32(cols-1) + 0.5*float32(rows-1))
-	xh := spaceH / (pho * float32(rows))
+// Shaders drawing a textured quad, used to composite overlays
+const (
+	passThruVS = `#version 450 core
+in vec2 pos;
+in vec2 uv;
+out vec2 vUV;
+void main() { gl_Position = vec4(pos, 0.0, 1.0); vUV = uv; }
+`
 
-	var side float32 = xw
-	if xh < side {
-		side = xh
-	}
+	passThruFS = `#version 450 core
+in vec2 vUV;
+out vec4 color;
+uniform sampler2D sampler;
+void main() { color = texture(sampler, vUV); }
+`
+)
 
-	// Compute actual size of grid
-	totH := float32(rows-1) * pho * side
-	totW := float32(cols-1)*side + 0.5*side*float32(rows-1)
+const (
+	minZoom = 0.5
+	maxZoom = 40.0
 
-	var bx float32 = -aspectRatio + (2.0*aspectRatio-totW)*0.5
-	var by float32 = -1.0 + (2.0-totH)*0.5
+	pho = 0.866025404 // sqrt(3/4)
+)
+
+// Weights are the ones of RingWeights: three per cell, or six in the
+// order of nbors in directed mode
+func SetupOGL(rows, cols, width, height int, directed bool) *ViewState {
+	gl.ClearColor(0.6, 0.6, 0.6, 1.0)
+	gl.ClearColor(0.3, 0.3, 0.3, 1.0)
+
+	stride := 3
+	if directed {
+		stride = 6
+	}
+	vs := &ViewState{
+		rows:      rows,
+		cols:      cols,
+		directed:  directed,
+		zoom:      1.0,
+		colors:    make([]float32, rows*cols),
+		weights:   make([]float32, rows*cols*stride),
+		highlight: make([]float32, rows*cols),
+		kinds:     make([]float32, rows*cols),
+	}
+	for k := range vs.colors {
+		vs.colors[k] = rand.Float32()
+	}
+	for k := range vs.weights {
+		vs.weights[k] = rand.Float32()
+	}
+	vs.layout(width, height)
 
 	vertexShaderSource := LoadFile("./shader_hex.vert")
 	fragmentShaderSource := LoadFile("./shader_hex.frag")
 	geometryShaderSource := LoadFile("./shader_hex.geom",
-		"INV_ASPECT_RATIO", 1.0/aspectRatio,
-		"HEX_SIDE", side,
-		"PHO", pho)
+		"PHO", pho,
+		"DIRECTED", vs.directed)
 
 	/*
 		vShader := glad.NewShader(vertexShaderSource, gl.VERTEX_SHADER)
@@ -97,67 +132,24 @@ func SetupOGL(rows, cols int, aspectRatio float32) *ViewState {
 		gShader.Delete()
 	*/
 
-	vertices := make([]float32, rows*cols*2)
-	weights := make([]float32, rows*cols*3)
-	colors := make([]float32, rows*cols)
-	// Fill the vertices of the hex grid centers
-	for i, k := 0, 0; i < rows; i++ {
-		for j := 0; j < cols; j, k = j+1, k+1 {
-			vertices[2*k+0] = bx + float32(i%2)*side*0.5 + float32(j+i/2)*side
-			vertices[2*k+1] = by + float32(i)*pho*side
-			colors[k] = rand.Float32()
-			weights[3*k+0] = rand.Float32()
-			weights[3*k+1] = rand.Float32()
-			weights[3*k+2] = rand.Float32()
-		}
+	// Directed weights are interleaved in the same buffer
+	attrs := []glad.Attr{{0, "vert", 4}, {1, "color", 1}, {2, "weights", 3}, {3, "highlight", 1}, {4, "kind", 1}}
+	if vs.directed {
+		attrs = append(attrs, glad.Attr{2, "weightsB", 3})
 	}
 
-	autoGrid := glad.AutoBuild(&glad.Config{
+	// Built once: the layout only changes data, so resizing allocates nothing
+	vs.autoGrid = glad.AutoBuild(&glad.Config{
 		Shaders: []glad.Shader{
 			glad.NewShader(vertexShaderSource, gl.VERTEX_SHADER),
 			glad.NewShader(fragmentShaderSource, gl.FRAGMENT_SHADER),
 			glad.NewShader(geometryShaderSource, gl.GEOMETRY_SHADER),
 		},
-		Attributes: []glad.Attr{{0, "vert", 2}, {1, "color", 1}, {2, "weights", 3}},
-		Data:       [][]float32{vertices, colors, weights},
-		DataUsages: []uint32{gl.STATIC_DRAW, gl.DYNAMIC_DRAW, gl.DYNAMIC_DRAW},
+		Attributes: attrs,
+		Data:       [][]float32{vs.viewVertices(), vs.colors, vs.weights, vs.highlight, vs.kinds},
+		DataUsages: []uint32{gl.DYNAMIC_DRAW, gl.DYNAMIC_DRAW, gl.DYNAMIC_DRAW, gl.DYNAMIC_DRAW, gl.DYNAMIC_DRAW},
 		Primitives: gl.POINTS,
-		Offscreen:  &glad.Rect{0, 0, 800, 600},
-		ClearColor: []float32{0.6, 0.6, 0.6, 1.0},
-	})
-
-	passThruVS := `#version 450 core
-in vec2 pos;
-in vec2 uv;
-out vec2 vUV;
-void main() { gl_Position = vec4(pos, 0.0, 1.0); vUV = uv; }
-`
-
-	passThruFS := `#version 450 core
-in vec2 vUV;
-out vec4 color;
-uniform sampler2D sampler;
-void main() { color = texture(sampler, vUV); }
-`
-	autoLayout := glad.AutoBuild(&glad.Config{
-		Shaders: []glad.Shader{
-			glad.NewShader(passThruVS, gl.VERTEX_SHADER),
-			glad.NewShader(passThruFS, gl.FRAGMENT_SHADER),
-		},
-		Attributes: []glad.Attr{{0, "pos", 2}, {0, "uv", 2}},
-		Data: [][]float32{
-			[]float32{
-				-1, -1, 0.0, 0.0,
-				-1, 1, 0.0, 1.0,
-				1, -1, 1.0, 0.0,
-				1, 1, 1.0, 1.0,
-			},
-		},
-		DataUsages: []uint32{gl.STATIC_DRAW},
-		Primitives: gl.TRIANGLE_STRIP,
-		Textures:   []glad.Texture{autoGrid.BgTxr},
 	})
-	//, 0.3, 0.3, 0.3, 1.0}
 
 	/*
 		var (
@@ -210,39 +202,123 @@ void main() { color = texture(sampler, vUV); }
 		fbo_env.Texture(gl.COLOR_ATTACHMENT0, txr_env)
 	*/
 
-	return &ViewState{
-		rows,
-		cols,
-		//program,
-		//vao,
-		//vbo_c,
-		//vbo_w,
-		//fbo_grid,
-		//fbo_env,
-		//txr_grid,
-		//txr_env,
-		//rows * cols,
-		vertices,
-		autoGrid,
-		autoLayout,
+	return vs
+}
+
+// Computes the layout for an area of the given size.
+// The view fills the framebuffer until it is moved with Place
+func (vs *ViewState) Resize(width, height int) {
+	vs.layout(width, height)
+	vs.updateVertices()
+}
+
+// Places hex centers to fit an area of the given size
+func (vs *ViewState) layout(width, height int) {
+	rows, cols := vs.rows, vs.cols
+	aspectRatio := float32(width) / float32(height)
+
+	// We want to fit the points in 90% of the real estate
+	var spaceW float32 = 0.9 * 2.0 * aspectRatio
+	var spaceH float32 = 0.9 * 2.0
+
+	// Total width is x*(cols-1) + 0.5*x*(rows-1)
+	// Total height is pho*x*rows
+	// Where x is the distance between centers of hexagons
+	// We want to maximize x, so find the smallest x so that
+	// spaceW = x * (cols-1) + 0.5*x*(rows-1) = x * (cols - 1 + 0.5 * (rows - 1))
+	// spaceH = pho * x * rows
+	xw := spaceW / (float32(cols-1) + 0.5*float32(rows-1))
+	xh := spaceH / (pho * float32(rows))
+
+	var side float32 = xw
+	if xh < side {
+		side = xh
+	}
+
+	// Compute actual size of grid
+	totH := float32(rows-1) * pho * side
+	totW := float32(cols-1)*side + 0.5*side*float32(rows-1)
+
+	var bx float32 = -aspectRatio + (2.0*aspectRatio-totW)*0.5
+	var by float32 = -1.0 + (2.0-totH)*0.5
+
+	vertices := make([]float32, rows*cols*2)
+	// Fill the vertices of the hex grid centers
+	for i, k := 0, 0; i < rows; i++ {
+		for j := 0; j < cols; j, k = j+1, k+1 {
+			vertices[2*k+0] = bx + float32(i%2)*side*0.5 + float32(j+i/2)*side
+			vertices[2*k+1] = by + float32(i)*pho*side
+		}
 	}
+
+	vs.width, vs.height = width, height
+	vs.aspect, vs.side = aspectRatio, side
+	vs.bx, vs.by = bx, by
+	vs.vertices = vertices
+
+	vs.x, vs.y = 0, 0
+	vs.fbW, vs.fbH = width, height
+}
+
+// Moves the view at pixel position (x,y) from the top-left corner of a
+// framebuffer of size fbW*fbH. The view keeps the size given to Resize
+func (vs *ViewState) Place(x, y, fbW, fbH int) {
+	vs.x, vs.y = x, y
+	vs.fbW, vs.fbH = fbW, fbH
+}
+
+// Checks if pixel (px,py) of the framebuffer is inside the view
+func (vs *ViewState) Contains(px, py float32) bool {
+	return px >= float32(vs.x) && px < float32(vs.x+vs.width) &&
+		py >= float32(vs.y) && py < float32(vs.y+vs.height)
+}
+
+// Converts pixel (px,py) of the framebuffer to screen coordinates of
+// the view (NDC, with x scaled by aspect ratio)
+func (vs *ViewState) FromPixels(px, py float32) (float32, float32) {
+	lx, ly := px-float32(vs.x), py-float32(vs.y)
+	rx := 2.0*lx/float32(vs.width) - 1.0
+	ry := 2.0*(float32(vs.height)-ly)/float32(vs.height) - 1.0
+	return rx * vs.aspect, ry
+}
+
+// Converts a displacement in pixels to a displacement in screen coordinates
+func (vs *ViewState) PixelsToScreen(dx, dy float32) (float32, float32) {
+	return 2.0 * dx / float32(vs.height), -2.0 * dy / float32(vs.height)
 }
 
 func (vs *ViewState) SetColors(colors []float32) {
+	vs.colors = colors
 	vs.autoGrid.VBOs[1].BufferSubData32(colors, 0)
 	//vs.vbo_c.BufferSubData32(colors, 0)
 }
 
 func (vs *ViewState) SetWeights(weights []float32) {
+	vs.weights = weights
 	//vs.vbo_w.BufferSubData32(weights, 0)
 	vs.autoGrid.VBOs[2].BufferSubData32(weights, 0)
 }
 
+// Sets the type of cells, 1 for inhibitory and 0 for excitatory
+func (vs *ViewState) SetKinds(kinds []float32) {
+	vs.kinds = kinds
+	vs.autoGrid.VBOs[4].BufferSubData32(kinds, 0)
+}
+
 func (vs *ViewState) DrawFrame() {
 	//bgCol := []float32{0.6, 0.6, 0.6, 1.0, 0.3, 0.3, 0.3, 1.0}
 
+	// Draw in the area of the view only, GL counts rows from the bottom
+	x, y, w, h := int32(vs.x), int32(vs.fbH-vs.y-vs.height), int32(vs.width), int32(vs.height)
+	gl.Viewport(x, y, w, h)
+	gl.Enable(gl.SCISSOR_TEST)
+	gl.Scissor(x, y, w, h)
+	gl.ClearColor(0.6, 0.6, 0.6, 1.0)
+	gl.Clear(gl.COLOR_BUFFER_BIT)
 	vs.autoGrid.AutoDraw()
-	vs.autoLayout.AutoDraw()
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.ClearColor(0.3, 0.3, 0.3, 1.0)
+	gl.Viewport(0, 0, int32(vs.fbW), int32(vs.fbH))
 
 	/*
 		vs.program.Use()
@@ -268,19 +344,109 @@ func (vs *ViewState) DrawFrame() {
 	*/
 }
 
+// Uploads hex centers transformed by the current view. The hex side times
+// the zoom is passed as third component so the geometry shader can scale
+// the hexagons as well, and the inverse aspect ratio as fourth
+func (vs *ViewState) updateVertices() {
+	vs.autoGrid.VBOs[0].BufferSubData32(vs.viewVertices(), 0)
+}
+
+func (vs *ViewState) viewVertices() []float32 {
+	verts := make([]float32, len(vs.vertices)/2*4)
+	for k := 0; k < len(vs.vertices)/2; k++ {
+		verts[4*k+0] = vs.vertices[2*k+0]*vs.zoom + vs.panX
+		verts[4*k+1] = vs.vertices[2*k+1]*vs.zoom + vs.panY
+		verts[4*k+2] = vs.side * vs.zoom
+		verts[4*k+3] = 1.0 / vs.aspect
+	}
+	return verts
+}
+
+// Maps a point from screen space to world space, undoing pan and zoom
+func (vs *ViewState) ToWorld(x, y float32) (float32, float32) {
+	return (x - vs.panX) / vs.zoom, (y - vs.panY) / vs.zoom
+}
+
+// Zooms by factor f keeping the screen point (x,y) fixed
+func (vs *ViewState) Zoom(f, x, y float32) {
+	nz := vs.zoom * f
+	if nz < minZoom {
+		nz = minZoom
+	} else if nz > maxZoom {
+		nz = maxZoom
+	}
+	wx, wy := vs.ToWorld(x, y)
+	vs.zoom = nz
+	vs.panX = x - wx*nz
+	vs.panY = y - wy*nz
+	vs.updateVertices()
+}
+
+// Moves the view by (dx,dy) in screen space
+func (vs *ViewState) Pan(dx, dy float32) {
+	vs.panX += dx
+	vs.panY += dy
+	vs.updateVertices()
+}
+
+// Restores the view fitting the whole grid
+func (vs *ViewState) ResetView() {
+	vs.zoom, vs.panX, vs.panY = 1.0, 0.0, 0.0
+	vs.updateVertices()
+}
+
+// Returns the grid cell under the screen point (x,y), or (-1,-1) when the
+// point falls outside the grid. Cells are laid out in axial coordinates
+// (column, row), so we invert the layout and round to the nearest hexagon
 func (vs *ViewState) NearestVertex(x, y float32) (int, int) {
-	mx, my := -1, -1
-	var minDist float32
-	for i, k := 0, 0; i < vs.rows; i++ {
-		for j := 0; j < vs.cols; j, k = j+1, k+1 {
-			pt := vs.vertices[2*k : 2*(k+1)] // Point to compare to xy
-			dist := (pt[0]-x)*(pt[0]-x) + (pt[1]-y)*(pt[1]-y)
-			if mx < 0 || dist < minDist {
-				minDist = dist
-				mx = j
-				my = i
-			}
-		}
+	x, y = vs.ToWorld(x, y)
+	r := float64((y - vs.by) / (pho * vs.side))
+	q := float64((x-vs.bx)/vs.side) - 0.5*r
+	s := -q - r
+	// Cube rounding: the coordinate with the largest error is recomputed
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	if dq > dr && dq > ds {
+		rq = -rr - rs
+	} else if dr > ds {
+		rr = -rq - rs
+	}
+	mx, my := int(rq), int(rr)
+	if mx < 0 || mx >= vs.cols || my < 0 || my >= vs.rows {
+		return -1, -1
 	}
 	return mx, my
 }
+
+// Returns the cell under the screen point (x,y) and the neighbour k in
+// nbors which is closest to the point, or (-1,-1,-1) outside the grid
+func (vs *ViewState) NearestEdge(x, y float32) (int, int, int) {
+	cx, cy := vs.NearestVertex(x, y)
+	if cx < 0 {
+		return -1, -1, -1
+	}
+	// Direction from the hexagon center to the point
+	wx, wy := vs.ToWorld(x, y)
+	c := vs.vertices[2*(cy*vs.cols+cx):]
+	dx, dy := wx-c[0], wy-c[1]
+	best, bk := float32(0), 0
+	for k, n := range nbors {
+		// Direction of the k-th neighbour
+		nx, ny := float32(n.x_)+0.5*float32(n.y_), pho*float32(n.y_)
+		if d := dx*nx + dy*ny; k == 0 || d > best {
+			best, bk = d, k
+		}
+	}
+	return cx, cy, bk
+}
+
+// Highlights the cell (x,y), pass (-1,-1) to remove the highlight
+func (vs *ViewState) SetHighlight(x, y int) {
+	for k := range vs.highlight {
+		vs.highlight[k] = 0
+	}
+	if x >= 0 && y >= 0 {
+		vs.highlight[y*vs.cols+x] = 1
+	}
+	vs.autoGrid.VBOs[3].BufferSubData32(vs.highlight, 0)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestNearestVertex(t *testing.T) {
+	vs := &ViewState{rows: 5, cols: 7, side: 0.1, bx: -0.3, by: -0.2, zoom: 2, panX: 0.1, panY: -0.05}
+
+	for i := 0; i < vs.rows; i++ {
+		for j := 0; j < vs.cols; j++ {
+			// Center of the hexagon in screen space, slightly off center
+			x := (vs.bx+float32(i%2)*vs.side*0.5+float32(j+i/2)*vs.side)*vs.zoom + vs.panX
+			y := (vs.by+float32(i)*pho*vs.side)*vs.zoom + vs.panY
+			for _, d := range [][2]float32{{0, 0}, {0.03, 0.01}, {-0.02, -0.04}} {
+				if nx, ny := vs.NearestVertex(x+d[0]*vs.zoom, y+d[1]*vs.zoom); nx != j || ny != i {
+					t.Error("Wrong vertex", j, i, d, "got", nx, ny)
+				}
+			}
+		}
+	}
+
+	if nx, ny := vs.NearestVertex(-10, -10); nx != -1 || ny != -1 {
+		t.Error("Point outside grid should not be picked, got", nx, ny)
+	}
+}